@@ -0,0 +1,187 @@
+// Copyright (c) 2019, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package editorconfig
+
+import (
+	"container/list"
+	"regexp"
+	"sync"
+)
+
+// Cache keeps track of work which can be reused across many calls to
+// Query.Find, such as parsed EditorConfig files and compiled patterns.
+//
+// A nil file stored for a directory means that the directory is known to not
+// contain an EditorConfig file.
+//
+// Caches may also implement FileDeleter to support Query.Invalidate.
+type Cache interface {
+	LoadFile(dir string) (file *File, ok bool)
+	StoreFile(dir string, file *File)
+
+	LoadRegexp(pattern string) (rx *regexp.Regexp, ok bool)
+	StoreRegexp(pattern string, rx *regexp.Regexp)
+}
+
+// FileDeleter is implemented by caches which can forget the file stored for a
+// directory, as required by Query.Invalidate.
+type FileDeleter interface {
+	DeleteFile(dir string)
+}
+
+// SyncCache is a Cache which is safe for concurrent use by multiple
+// goroutines. Its zero value is ready to use.
+type SyncCache struct {
+	files   sync.Map // map[string]*File
+	regexps sync.Map // map[string]*regexp.Regexp
+}
+
+func (c *SyncCache) LoadFile(dir string) (*File, bool) {
+	v, ok := c.files.Load(dir)
+	if !ok {
+		return nil, false
+	}
+	return v.(*File), true
+}
+
+func (c *SyncCache) StoreFile(dir string, file *File) { c.files.Store(dir, file) }
+
+func (c *SyncCache) DeleteFile(dir string) { c.files.Delete(dir) }
+
+func (c *SyncCache) LoadRegexp(pattern string) (*regexp.Regexp, bool) {
+	v, ok := c.regexps.Load(pattern)
+	if !ok {
+		return nil, false
+	}
+	return v.(*regexp.Regexp), true
+}
+
+func (c *SyncCache) StoreRegexp(pattern string, rx *regexp.Regexp) { c.regexps.Store(pattern, rx) }
+
+// LRUCache is a Cache which holds at most MaxEntries files and compiled
+// patterns, evicting the least recently used ones first. It is useful for
+// long-running programs which would otherwise cache every directory they
+// visit; other eviction policies can be implemented via the Cache interface.
+//
+// LRUCache is safe for concurrent use by multiple goroutines.
+// Its zero value is ready to use, and does not evict any entries.
+type LRUCache struct {
+	// MaxEntries is the maximum number of entries kept in the cache,
+	// counting both files and patterns. Zero means no limit.
+	MaxEntries int
+
+	mu      sync.Mutex
+	ll      *list.List // of *lruEntry, most recently used first
+	entries map[lruKey]*list.Element
+}
+
+type lruKey struct {
+	regexp bool // whether the key is a pattern rather than a directory
+	key    string
+}
+
+type lruEntry struct {
+	key   lruKey
+	value any // *File or *regexp.Regexp
+}
+
+func (c *LRUCache) load(key lruKey) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return elem.Value.(*lruEntry).value, true
+}
+
+func (c *LRUCache) store(key lruKey, value any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.ll = list.New()
+		c.entries = make(map[lruKey]*list.Element)
+	}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*lruEntry).value = value
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.ll.PushFront(&lruEntry{key, value})
+	for c.MaxEntries > 0 && c.ll.Len() > c.MaxEntries {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+// Len returns the number of entries in the cache.
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func (c *LRUCache) LoadFile(dir string) (*File, bool) {
+	v, ok := c.load(lruKey{key: dir})
+	if !ok {
+		return nil, false
+	}
+	return v.(*File), true
+}
+
+func (c *LRUCache) StoreFile(dir string, file *File) { c.store(lruKey{key: dir}, file) }
+
+func (c *LRUCache) DeleteFile(dir string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[lruKey{key: dir}]; ok {
+		c.ll.Remove(elem)
+		delete(c.entries, lruKey{key: dir})
+	}
+}
+
+func (c *LRUCache) LoadRegexp(pattern string) (*regexp.Regexp, bool) {
+	v, ok := c.load(lruKey{regexp: true, key: pattern})
+	if !ok {
+		return nil, false
+	}
+	return v.(*regexp.Regexp), true
+}
+
+func (c *LRUCache) StoreRegexp(pattern string, rx *regexp.Regexp) {
+	c.store(lruKey{regexp: true, key: pattern}, rx)
+}
+
+// mapCache implements Cache with plain maps, either of which may be nil to
+// disable caching. It is not safe for concurrent use.
+type mapCache struct {
+	files   map[string]*File
+	regexps map[string]*regexp.Regexp
+}
+
+func (c mapCache) LoadFile(dir string) (*File, bool) {
+	file, ok := c.files[dir]
+	return file, ok
+}
+
+func (c mapCache) StoreFile(dir string, file *File) {
+	if c.files != nil {
+		c.files[dir] = file
+	}
+}
+
+func (c mapCache) DeleteFile(dir string) { delete(c.files, dir) }
+
+func (c mapCache) LoadRegexp(pattern string) (*regexp.Regexp, bool) {
+	rx, ok := c.regexps[pattern]
+	return rx, ok
+}
+
+func (c mapCache) StoreRegexp(pattern string, rx *regexp.Regexp) {
+	if c.regexps != nil {
+		c.regexps[pattern] = rx
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2019, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package editorconfig
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// DecodeReader wraps a reader of a file's contents so that it decodes them
+// as per the charset property, returning UTF-8. Byte order marks are removed.
+//
+// The contents are returned unchanged when charset is "utf-8" or unset, and an
+// error is returned for any value which CharsetValid does not accept.
+// Malformed input is replaced by utf8.RuneError.
+//
+// The decoding is implemented in this package, so that it does not need to
+// depend on golang.org/x/text.
+func (s Section) DecodeReader(r io.Reader) (io.Reader, error) {
+	switch charset := s.Charset(); charset {
+	case "", "utf-8":
+		return r, nil
+	case "utf-8-bom":
+		br := bufio.NewReader(r)
+		if bom, _ := br.Peek(3); string(bom) == "\ufeff" {
+			br.Discard(3)
+		}
+		return br, nil
+	case "latin1":
+		return &decoder{r: bufio.NewReader(r), next: nextLatin1}, nil
+	case "utf-16be", "utf-16le":
+		br := bufio.NewReader(r)
+		bom := "\xfe\xff"
+		next := nextUTF16BE
+		if charset == "utf-16le" {
+			bom = "\xff\xfe"
+			next = nextUTF16LE
+		}
+		if b, _ := br.Peek(2); string(b) == bom {
+			br.Discard(2)
+		}
+		return &decoder{r: br, next: next}, nil
+	default:
+		return nil, fmt.Errorf("invalid charset value: %q", charset)
+	}
+}
+
+// decoder is a reader which decodes runes one at a time from a source reader,
+// encoding them as UTF-8.
+type decoder struct {
+	r    *bufio.Reader
+	next func(r *bufio.Reader) (rune, error)
+	buf  []byte // decoded bytes which have not been read yet
+	err  error
+}
+
+func (d *decoder) Read(p []byte) (int, error) {
+	for len(d.buf) < len(p) && d.err == nil {
+		r, err := d.next(d.r)
+		if err != nil {
+			d.err = err
+			break
+		}
+		d.buf = utf8.AppendRune(d.buf, r)
+	}
+	n := copy(p, d.buf)
+	d.buf = append(d.buf[:0], d.buf[n:]...)
+	if n == 0 && d.err != nil {
+		return 0, d.err
+	}
+	return n, nil
+}
+
+func nextLatin1(r *bufio.Reader) (rune, error) {
+	b, err := r.ReadByte()
+	return rune(b), err
+}
+
+func nextUTF16BE(r *bufio.Reader) (rune, error) {
+	return nextUTF16(r, func(b []byte) rune { return rune(b[0])<<8 | rune(b[1]) })
+}
+
+func nextUTF16LE(r *bufio.Reader) (rune, error) {
+	return nextUTF16(r, func(b []byte) rune { return rune(b[1])<<8 | rune(b[0]) })
+}
+
+// nextUTF16 decodes a rune from r, using unit to decode each two-byte code
+// unit in the right byte order.
+func nextUTF16(r *bufio.Reader, unit func([]byte) rune) (rune, error) {
+	b, err := r.Peek(2)
+	switch {
+	case len(b) == 1:
+		// A trailing odd byte.
+		r.Discard(1)
+		return utf8.RuneError, nil
+	case err != nil:
+		return 0, err
+	}
+	r.Discard(2)
+	r1 := unit(b)
+	if !utf16.IsSurrogate(r1) {
+		return r1, nil
+	}
+	// Only consume the next code unit if it completes the surrogate pair.
+	if b, err := r.Peek(2); err == nil {
+		if dec := utf16.DecodeRune(r1, unit(b)); dec != utf8.RuneError {
+			r.Discard(2)
+			return dec, nil
+		}
+	}
+	return utf8.RuneError, nil
+}
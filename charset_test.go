@@ -0,0 +1,54 @@
+// Copyright (c) 2019, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package editorconfig
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecodeReader(t *testing.T) {
+	tests := []struct {
+		charset string
+		in, out string
+	}{
+		{"", "\ufeffé", "\ufeffé"},
+		{"utf-8", "\ufeffé", "\ufeffé"},
+		{"utf-8-bom", "\ufeffé\ufeff", "é\ufeff"},
+		{"utf-8-bom", "é", "é"},
+		{"latin1", "caf\xe9 \xff", "café ÿ"},
+		{"utf-16be", "\xfe\xff\x00a\x00\xe9", "aé"},
+		{"utf-16le", "\xff\xfea\x00\xe9\x00", "aé"},
+		{"utf-16le", "a\x00", "a"},
+		{"utf-16be", "\xd8\x3d\xde\x00", "😀"},
+		{"utf-16le", "\x3d\xd8\x00\xde", "😀"},
+		{"utf-16be", "\xd8\x3d\x00a", "�a"}, // unpaired surrogate
+		{"utf-16be", "\x00a\x00", "a�"},     // odd length
+	}
+	for _, tc := range tests {
+		var s Section
+		if tc.charset != "" {
+			s.Add(Property{Name: "charset", Value: tc.charset})
+		}
+		r, err := s.DecodeReader(iotest.OneByteReader(strings.NewReader(tc.in)))
+		if err != nil {
+			t.Errorf("DecodeReader with %q: unexpected error: %v", tc.charset, err)
+			continue
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Errorf("DecodeReader with %q: unexpected read error: %v", tc.charset, err)
+			continue
+		}
+		if string(got) != tc.out {
+			t.Errorf("DecodeReader(%q) with %q: want %q, got %q", tc.in, tc.charset, tc.out, got)
+		}
+	}
+
+	if _, err := section("charset", "utf8").DecodeReader(strings.NewReader("")); err == nil {
+		t.Errorf("DecodeReader with an invalid charset did not error")
+	}
+}
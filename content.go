@@ -0,0 +1,358 @@
+// Copyright (c) 2019, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package editorconfig
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// Apply enforces the text properties of a section on a file's contents,
+// returning the result. The supported properties are end_of_line,
+// trim_trailing_whitespace, and insert_final_newline; unset properties leave
+// the contents untouched.
+//
+// Note that when insert_final_newline is "false", any trailing newlines are
+// removed, as the spec requires files to not end with a newline.
+//
+// An error is returned if any of the supported properties has an invalid value.
+//
+// It is equivalent to ApplyOptions{}.Apply.
+func (s Section) Apply(content []byte) ([]byte, error) {
+	return ApplyOptions{}.Apply(s, content)
+}
+
+// ApplyOptions allows fine-grained control of how the text properties of a
+// section are enforced by Apply and Check.
+type ApplyOptions struct {
+	// PreserveTrailingSpacesAtLeast, if positive, keeps the trailing
+	// whitespace of lines ending with at least this many spaces when
+	// trim_trailing_whitespace is "true". For example, a value of 2 keeps
+	// the hard line breaks in Markdown files. Lines with only whitespace
+	// are still trimmed.
+	PreserveTrailingSpacesAtLeast int
+}
+
+// Apply is like Section.Apply, but configured by the options.
+func (o ApplyOptions) Apply(s Section, content []byte) ([]byte, error) {
+	// Validate all values first, so that no work is done on errors.
+	eol, err := s.eolValue()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"trim_trailing_whitespace", "insert_final_newline"} {
+		if _, _, err := s.boolValue(name); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range []string{"trim_trailing_whitespace", "end_of_line", "insert_final_newline"} {
+		if prop := s.Lookup(name); prop != nil {
+			content, _ = o.applyProperty(*prop, content, eol)
+		}
+	}
+	return content, nil
+}
+
+// ApplyProperty enforces a single text property on a file's contents,
+// returning the result and whether it differs from the original contents.
+// The supported properties are the same as in Section.Apply; any other
+// property leaves the contents untouched. An error is returned if the
+// property's value is invalid.
+//
+// Applying trim_trailing_whitespace, end_of_line, and insert_final_newline in
+// that order is equivalent to Section.Apply, except that a final newline
+// added to contents without any line terminators is always "\n", as
+// end_of_line isn't known.
+//
+// It is equivalent to ApplyOptions{}.ApplyProperty.
+func ApplyProperty(prop Property, content []byte) ([]byte, bool, error) {
+	return ApplyOptions{}.ApplyProperty(prop, content)
+}
+
+// ApplyProperty is like the ApplyProperty function, but configured by the
+// options.
+func (o ApplyOptions) ApplyProperty(prop Property, content []byte) ([]byte, bool, error) {
+	out, err := o.applyProperty(prop, content, "")
+	if err != nil {
+		return nil, false, err
+	}
+	return out, !bytes.Equal(out, content), nil
+}
+
+// applyProperty implements ApplyProperty. The eol line terminator is used
+// when adding a final newline; when empty, it is detected from content.
+func (o ApplyOptions) applyProperty(prop Property, content []byte, eol string) ([]byte, error) {
+	s := Section{Properties: []Property{prop}}
+	switch prop.Name {
+	case "end_of_line":
+		eol, err := s.eolValue()
+		if err != nil || eol == "" {
+			return content, err
+		}
+		return mapLines(content, func(l line) line {
+			if len(l.eol) > 0 {
+				l.eol = []byte(eol)
+			}
+			return l
+		}), nil
+	case "trim_trailing_whitespace":
+		trim, _, err := s.boolValue(prop.Name)
+		if err != nil || !trim {
+			return content, err
+		}
+		return mapLines(content, func(l line) line {
+			if !o.preserveTrailing(l.text) {
+				l.text = bytes.TrimRight(l.text, " \t")
+			}
+			return l
+		}), nil
+	case "insert_final_newline":
+		final, finalSet, err := s.boolValue(prop.Name)
+		if err != nil || !finalSet {
+			return content, err
+		}
+		if !final {
+			return bytes.TrimRight(content, "\r\n"), nil
+		}
+		if len(content) > 0 && !endsWithNewline(content) {
+			if eol == "" {
+				eol = detectEOL(content)
+			}
+			return append(content[:len(content):len(content)], eol...), nil
+		}
+	}
+	return content, nil
+}
+
+// mapLines returns a copy of content with each of its lines modified by fn.
+func mapLines(content []byte, fn func(line) line) []byte {
+	out := make([]byte, 0, len(content))
+	for _, l := range splitLines(content) {
+		l = fn(l)
+		out = append(out, l.text...)
+		out = append(out, l.eol...)
+	}
+	return out
+}
+
+// Reindent rewrites the leading indentation of each line in a file's contents
+// to follow indent_style, returning the result. When it is "tab", runs of
+// spaces are replaced by tabs, leaving any remainder which is too short for
+// a tab as spaces; when it is "space", tabs are expanded to spaces. Only the
+// whitespace before the first other character in each line is modified.
+//
+// Tabs are considered as wide as tab_width, falling back to indent_size. The
+// contents are returned unchanged when indent_style is unset, and an error is
+// returned if it is invalid or if the width of a tab is unknown.
+func (s Section) Reindent(content []byte) ([]byte, error) {
+	style := s.IndentStyleValue()
+	if style == IndentUnset {
+		if value := s.Get("indent_style"); value != "" {
+			return nil, fmt.Errorf("invalid indent_style value: %q", value)
+		}
+		return content, nil
+	}
+	width, err := s.tabWidthValue()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(content))
+	for _, line := range splitLines(content) {
+		indent := len(line.text) - len(bytes.TrimLeft(line.text, " \t"))
+		col := 0
+		for _, b := range line.text[:indent] {
+			if b == '\t' {
+				col += width - col%width
+			} else {
+				col++
+			}
+		}
+		if style == IndentTab {
+			out = append(out, bytes.Repeat([]byte("\t"), col/width)...)
+			col %= width
+		}
+		out = append(out, bytes.Repeat([]byte(" "), col)...)
+		out = append(out, line.text[indent:]...)
+		out = append(out, line.eol...)
+	}
+	return out, nil
+}
+
+// VisualWidth returns the number of columns a line takes when displayed,
+// expanding each tab to the next multiple of tab_width. The width of a tab
+// falls back to indent_size if tab_width is unset, and to 8 if neither gives
+// a valid width. Any other character takes a single column.
+func (s Section) VisualWidth(line string) int {
+	width, err := s.tabWidthValue()
+	if err != nil {
+		width = 8
+	}
+	col := 0
+	for _, r := range line {
+		if r == '\t' {
+			col += width - col%width
+		} else {
+			col++
+		}
+	}
+	return col
+}
+
+// Violation is a problem found by Section.Check in a file's contents.
+type Violation struct {
+	// Line is the 1-based line number where the problem was found.
+	Line int
+	// Property is the name of the property which was violated.
+	Property string
+	// Msg describes the problem.
+	Msg string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("line %d: %s: %s", v.Line, v.Property, v.Msg)
+}
+
+// Check reports the ways in which a file's contents do not follow the text
+// properties of a section, without modifying them. Only properties which are
+// set and valid are checked; see Apply for the supported properties.
+//
+// It is equivalent to ApplyOptions{}.Check.
+func (s Section) Check(content []byte) []Violation {
+	return ApplyOptions{}.Check(s, content)
+}
+
+// Check is like Section.Check, but configured by the options.
+func (o ApplyOptions) Check(s Section, content []byte) []Violation {
+	var violations []Violation
+	eol, _ := s.eolValue()
+	trim, _, _ := s.boolValue("trim_trailing_whitespace")
+	final, finalSet, _ := s.boolValue("insert_final_newline")
+
+	lines := splitLines(content)
+	for i, line := range lines {
+		if trim && !o.preserveTrailing(line.text) && len(bytes.TrimRight(line.text, " \t")) < len(line.text) {
+			violations = append(violations, Violation{
+				Line: i + 1, Property: "trim_trailing_whitespace",
+				Msg: "trailing whitespace",
+			})
+		}
+		if eol != "" && len(line.eol) > 0 && string(line.eol) != eol {
+			violations = append(violations, Violation{
+				Line: i + 1, Property: "end_of_line",
+				Msg: fmt.Sprintf("line ends with %q instead of %q", line.eol, eol),
+			})
+		}
+	}
+	if finalSet && len(content) > 0 {
+		if final && !endsWithNewline(content) {
+			violations = append(violations, Violation{
+				Line: len(lines), Property: "insert_final_newline",
+				Msg: "missing final newline",
+			})
+		} else if !final && endsWithNewline(content) {
+			violations = append(violations, Violation{
+				Line: len(lines), Property: "insert_final_newline",
+				Msg: "unexpected final newline",
+			})
+		}
+	}
+	return violations
+}
+
+// preserveTrailing reports whether the trailing whitespace of a line should
+// be kept as per PreserveTrailingSpacesAtLeast.
+func (o ApplyOptions) preserveTrailing(text []byte) bool {
+	n := o.PreserveTrailingSpacesAtLeast
+	if n <= 0 || len(bytes.TrimSpace(text)) == 0 {
+		return false
+	}
+	return len(text)-len(bytes.TrimRight(text, " ")) >= n
+}
+
+// line is a line of text along with its terminator, which is empty for a
+// trailing line without a newline.
+type line struct {
+	text, eol []byte
+}
+
+// splitLines splits content into lines, recognizing "\n", "\r\n", and "\r" as
+// line terminators.
+func splitLines(content []byte) []line {
+	var lines []line
+	for len(content) > 0 {
+		i := bytes.IndexAny(content, "\r\n")
+		if i < 0 {
+			lines = append(lines, line{text: content})
+			break
+		}
+		n := 1
+		if content[i] == '\r' && i+1 < len(content) && content[i+1] == '\n' {
+			n = 2
+		}
+		lines = append(lines, line{text: content[:i], eol: content[i : i+n]})
+		content = content[i+n:]
+	}
+	return lines
+}
+
+func endsWithNewline(content []byte) bool {
+	return bytes.HasSuffix(content, []byte("\n")) || bytes.HasSuffix(content, []byte("\r"))
+}
+
+// detectEOL returns the first line terminator found in content, or "\n" if
+// there is none.
+func detectEOL(content []byte) string {
+	if i := bytes.IndexAny(content, "\r\n"); i >= 0 {
+		if content[i] == '\n' {
+			return "\n"
+		}
+		if i+1 < len(content) && content[i+1] == '\n' {
+			return "\r\n"
+		}
+		return "\r"
+	}
+	return "\n"
+}
+
+// eolValue is like EndOfLine, but it returns an error for invalid values.
+func (s Section) eolValue() (string, error) {
+	eol := s.EndOfLine()
+	if value := s.Get("end_of_line"); value != "" && eol == "" {
+		return "", fmt.Errorf("invalid end_of_line value: %q", value)
+	}
+	return eol, nil
+}
+
+// tabWidthValue returns the width of a tab as per tab_width, falling back
+// to indent_size. An error is returned if neither is a positive integer.
+func (s Section) tabWidthValue() (int, error) {
+	if value := s.Get("tab_width"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid tab_width value: %q", value)
+		}
+		return n, nil
+	}
+	if n, err := strconv.Atoi(s.Get("indent_size")); err == nil && n > 0 {
+		return n, nil
+	}
+	return 0, fmt.Errorf("unknown tab width: tab_width and indent_size are unset")
+}
+
+// boolValue returns the value of a boolean property, and whether it is set.
+// An error is returned if the value is neither "true" nor "false".
+func (s Section) boolValue(name string) (value, set bool, err error) {
+	switch v := s.Get(name); v {
+	case "":
+		return false, false, nil
+	case "true":
+		return true, true, nil
+	case "false":
+		return false, true, nil
+	default:
+		return false, false, fmt.Errorf("invalid %s value: %q", name, v)
+	}
+}
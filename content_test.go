@@ -0,0 +1,202 @@
+// Copyright (c) 2019, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package editorconfig
+
+import (
+	"fmt"
+	"testing"
+)
+
+func section(props ...string) Section {
+	var s Section
+	for i := 0; i < len(props); i += 2 {
+		s.Add(Property{Name: props[i], Value: props[i+1]})
+	}
+	return s
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		props   []string
+		in, out string
+	}{
+		{nil, "foo  \r\nbar", "foo  \r\nbar"},
+		{[]string{"trim_trailing_whitespace", "true"}, "foo \t\nbar \n", "foo\nbar\n"},
+		{[]string{"trim_trailing_whitespace", "false"}, "foo \n", "foo \n"},
+		{[]string{"end_of_line", "lf"}, "a\r\nb\rc\n", "a\nb\nc\n"},
+		{[]string{"end_of_line", "crlf"}, "a\nb\r\n", "a\r\nb\r\n"},
+		{[]string{"insert_final_newline", "true"}, "a\nb", "a\nb\n"},
+		{[]string{"insert_final_newline", "true"}, "a\r\nb", "a\r\nb\r\n"},
+		{[]string{"insert_final_newline", "true"}, "", ""},
+		{[]string{"insert_final_newline", "false"}, "a\n\n", "a"},
+		{
+			[]string{"end_of_line", "crlf", "insert_final_newline", "true", "trim_trailing_whitespace", "true"},
+			"a \nb ", "a\r\nb\r\n",
+		},
+		{[]string{"end_of_line", "crlf", "insert_final_newline", "true"}, "a", "a\r\n"},
+	}
+	for _, tc := range tests {
+		got, err := section(tc.props...).Apply([]byte(tc.in))
+		if err != nil {
+			t.Errorf("Apply(%q) with %q: unexpected error: %v", tc.in, tc.props, err)
+			continue
+		}
+		if string(got) != tc.out {
+			t.Errorf("Apply(%q) with %q: want %q, got %q", tc.in, tc.props, tc.out, got)
+		}
+	}
+
+	if _, err := section("end_of_line", "lfcr").Apply(nil); err == nil {
+		t.Errorf("Apply with an invalid end_of_line did not error")
+	}
+	if _, err := section("insert_final_newline", "yes").Apply(nil); err == nil {
+		t.Errorf("Apply with an invalid insert_final_newline did not error")
+	}
+}
+
+func TestApplyProperty(t *testing.T) {
+	tests := []struct {
+		prop    Property
+		in, out string
+	}{
+		{Property{Name: "trim_trailing_whitespace", Value: "true"}, "foo \nbar\n", "foo\nbar\n"},
+		{Property{Name: "trim_trailing_whitespace", Value: "true"}, "foo\n", "foo\n"},
+		{Property{Name: "end_of_line", Value: "crlf"}, "a\nb\r\n", "a\r\nb\r\n"},
+		{Property{Name: "insert_final_newline", Value: "true"}, "a\r\nb", "a\r\nb\r\n"},
+		{Property{Name: "insert_final_newline", Value: "true"}, "a", "a\n"},
+		{Property{Name: "insert_final_newline", Value: "false"}, "a\n", "a"},
+		{Property{Name: "indent_style", Value: "tab"}, "  a \n", "  a \n"},
+		{Property{Name: "custom", Value: "anything"}, "a", "a"},
+	}
+	for _, tc := range tests {
+		got, changed, err := ApplyProperty(tc.prop, []byte(tc.in))
+		if err != nil {
+			t.Errorf("ApplyProperty(%q) with %v: unexpected error: %v", tc.in, tc.prop, err)
+			continue
+		}
+		if string(got) != tc.out {
+			t.Errorf("ApplyProperty(%q) with %v: want %q, got %q", tc.in, tc.prop, tc.out, got)
+		}
+		if want := tc.in != tc.out; changed != want {
+			t.Errorf("ApplyProperty(%q) with %v: want changed %t, got %t", tc.in, tc.prop, want, changed)
+		}
+	}
+
+	if _, _, err := ApplyProperty(Property{Name: "end_of_line", Value: "lfcr"}, nil); err == nil {
+		t.Errorf("ApplyProperty with an invalid end_of_line did not error")
+	}
+}
+
+func TestApplyPreserveTrailingSpaces(t *testing.T) {
+	s := section("trim_trailing_whitespace", "true")
+	opts := ApplyOptions{PreserveTrailingSpacesAtLeast: 2}
+	in := "hard  \nbreak\none \ntab\t\nmixed\t  \n   \n"
+	want := "hard  \nbreak\none\ntab\nmixed\t  \n\n"
+	got, err := opts.Apply(s, []byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+	var lines []int
+	for _, v := range opts.Check(s, []byte(in)) {
+		lines = append(lines, v.Line)
+	}
+	if want := "[3 4 6]"; fmt.Sprint(lines) != want {
+		t.Fatalf("want violations on lines %s, got %v", want, lines)
+	}
+}
+
+func TestReindent(t *testing.T) {
+	tests := []struct {
+		props   []string
+		in, out string
+	}{
+		{nil, "\t  a\n", "\t  a\n"},
+		{[]string{"indent_style", "tab", "indent_size", "4"}, "    a\n        b\n", "\ta\n\t\tb\n"},
+		{[]string{"indent_style", "tab", "indent_size", "4"}, "      a \t b", "\t  a \t b"},
+		{[]string{"indent_style", "tab", "indent_size", "2", "tab_width", "4"}, "  a\n    b\n", "  a\n\tb\n"},
+		{[]string{"indent_style", "space", "indent_size", "2"}, "\ta\r\n\t\tb\r\n", "  a\r\n    b\r\n"},
+		{[]string{"indent_style", "space", "tab_width", "8"}, "  \ta\n", "        a\n"},
+		{[]string{"indent_style", "space", "indent_size", "4"}, "a\tb\n", "a\tb\n"},
+		{[]string{"indent_style", "space", "indent_size", "4"}, "", ""},
+	}
+	for _, tc := range tests {
+		got, err := section(tc.props...).Reindent([]byte(tc.in))
+		if err != nil {
+			t.Errorf("Reindent(%q) with %q: unexpected error: %v", tc.in, tc.props, err)
+			continue
+		}
+		if string(got) != tc.out {
+			t.Errorf("Reindent(%q) with %q: want %q, got %q", tc.in, tc.props, tc.out, got)
+		}
+	}
+
+	if _, err := section("indent_style", "tabs").Reindent(nil); err == nil {
+		t.Errorf("Reindent with an invalid indent_style did not error")
+	}
+	if _, err := section("indent_style", "tab").Reindent(nil); err == nil {
+		t.Errorf("Reindent with an unknown tab width did not error")
+	}
+}
+
+func TestVisualWidth(t *testing.T) {
+	tests := []struct {
+		props []string
+		line  string
+		want  int
+	}{
+		{nil, "", 0},
+		{nil, "abc", 3},
+		{nil, "\tx", 9},
+		{nil, "ab\tx", 9},
+		{nil, "héllo", 5},
+		{[]string{"tab_width", "4"}, "\t\tx", 9},
+		{[]string{"tab_width", "4"}, "abc\td\te", 9},
+		{[]string{"indent_size", "2"}, "\tx", 3},
+		{[]string{"indent_size", "2", "tab_width", "4"}, "\tx", 5},
+		{[]string{"indent_size", "tab"}, "\tx", 9},
+		{[]string{"tab_width", "bad"}, "\tx", 9},
+	}
+	for _, tc := range tests {
+		if got := section(tc.props...).VisualWidth(tc.line); got != tc.want {
+			t.Errorf("VisualWidth(%q) with %q: want %d, got %d", tc.line, tc.props, tc.want, got)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		props []string
+		in    string
+		want  []string
+	}{
+		{nil, "foo  \r\nbar", nil},
+		{[]string{"trim_trailing_whitespace", "true"}, "foo\nbar \nbaz\t", []string{
+			`line 2: trim_trailing_whitespace: trailing whitespace`,
+			`line 3: trim_trailing_whitespace: trailing whitespace`,
+		}},
+		{[]string{"end_of_line", "lf"}, "a\nb\r\nc", []string{
+			`line 2: end_of_line: line ends with "\r\n" instead of "\n"`,
+		}},
+		{[]string{"insert_final_newline", "true"}, "a\nb", []string{
+			`line 2: insert_final_newline: missing final newline`,
+		}},
+		{[]string{"insert_final_newline", "false"}, "a\n", []string{
+			`line 1: insert_final_newline: unexpected final newline`,
+		}},
+		{[]string{"insert_final_newline", "true"}, "", nil},
+		{[]string{"end_of_line", "bogus"}, "a\r\n", nil},
+	}
+	for _, tc := range tests {
+		var got []string
+		for _, v := range section(tc.props...).Check([]byte(tc.in)) {
+			got = append(got, v.String())
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("Check(%q) with %q:\nwant %q\ngot  %q", tc.in, tc.props, tc.want, got)
+		}
+	}
+}
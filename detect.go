@@ -0,0 +1,221 @@
+// Copyright (c) 2019, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package editorconfig
+
+import (
+	"bytes"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DetectIndent guesses the indentation used by a file's contents, by looking
+// at the leading whitespace of its lines. The results can be used as the
+// values for the indent_style and indent_size properties; when the style is
+// IndentTab, size is zero, as indent_size defaults to "tab".
+//
+// If the contents have no indentation, or use tabs and spaces similarly
+// often, ok is false.
+func DetectIndent(content []byte) (style IndentStyle, size int, ok bool) {
+	tabLines, spaceLines := 0, 0
+	// Count how often each increase in indentation appears between
+	// consecutive lines indented with spaces.
+	var deltas [9]int
+	prevSpaces := 0
+	for _, line := range splitLines(content) {
+		text := line.text
+		if len(text) == 0 {
+			continue
+		}
+		spaces := 0
+		for spaces < len(text) && text[spaces] == ' ' {
+			spaces++
+		}
+		if spaces == len(text) {
+			continue // blank line
+		}
+		switch {
+		case text[0] == '\t':
+			tabLines++
+		case spaces > 0:
+			spaceLines++
+		}
+		if text[0] != '\t' {
+			if delta := spaces - prevSpaces; delta > 0 && delta < len(deltas) {
+				deltas[delta]++
+			}
+			prevSpaces = spaces
+		}
+	}
+
+	switch {
+	case tabLines > 2*spaceLines:
+		return IndentTab, 0, true
+	case spaceLines > 2*tabLines:
+		best, tied := 0, false
+		for delta, n := range deltas {
+			if n == 0 {
+				continue
+			}
+			if best == 0 || n > deltas[best] {
+				best, tied = delta, false
+			} else if n == deltas[best] {
+				tied = true
+			}
+		}
+		if best == 0 || tied {
+			return IndentUnset, 0, false
+		}
+		return IndentSpace, best, true
+	}
+	return IndentUnset, 0, false
+}
+
+// Generate creates an EditorConfig file from sample file contents keyed by
+// their names, by detecting their indentation and line endings.
+//
+// The result is a root file with a "[*]" section for the settings shared by
+// all samples, followed by a section for each file extension, such as
+// "[*.go]", sorted by extension. Samples without an extension only contribute
+// to the "[*]" section.
+func Generate(samples map[string][]byte) *File {
+	var allEOLs votes
+	allFinal := true
+	groups := make(map[string][]string)
+	for name, content := range samples {
+		if len(content) == 0 {
+			continue
+		}
+		if bytes.ContainsAny(content, "\r\n") {
+			allEOLs.add(eolValues[detectEOL(content)])
+		}
+		allFinal = allFinal && endsWithNewline(content)
+		if ext := path.Ext(filepath.ToSlash(name)); ext != "" {
+			groups[ext] = append(groups[ext], name)
+		}
+	}
+
+	file := &File{Root: true}
+	base := Section{Name: "*"}
+	baseEOL := allEOLs.winner()
+	if baseEOL != "" {
+		base.Add(Property{Name: "end_of_line", Value: baseEOL})
+		if allFinal {
+			base.Add(Property{Name: "insert_final_newline", Value: "true"})
+		}
+	}
+	if len(base.Properties) > 0 {
+		file.Sections = append(file.Sections, base)
+	}
+
+	exts := make([]string, 0, len(groups))
+	for ext := range groups {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	for _, ext := range exts {
+		var indents, eols votes
+		for _, name := range groups[ext] {
+			content := samples[name]
+			if style, size, ok := DetectIndent(content); ok {
+				indents.add(string(style) + " " + strconv.Itoa(size))
+			}
+			if bytes.ContainsAny(content, "\r\n") {
+				eols.add(eolValues[detectEOL(content)])
+			}
+		}
+		section := Section{Name: "*" + ext}
+		if style, size, ok := strings.Cut(indents.winner(), " "); ok {
+			section.Add(Property{Name: "indent_style", Value: style})
+			if size != "0" {
+				section.Add(Property{Name: "indent_size", Value: size})
+			}
+		}
+		if eol := eols.winner(); eol != "" && eol != baseEOL {
+			section.Add(Property{Name: "end_of_line", Value: eol})
+		}
+		if len(section.Properties) > 0 {
+			file.Sections = append(file.Sections, section)
+		}
+	}
+	return file
+}
+
+// eolValues maps line endings to their end_of_line values.
+var eolValues = map[string]string{"\n": "lf", "\r\n": "crlf", "\r": "cr"}
+
+// votes counts how many times each value was seen.
+type votes map[string]int
+
+func (v *votes) add(value string) {
+	if *v == nil {
+		*v = make(votes)
+	}
+	(*v)[value]++
+}
+
+// winner returns the value seen most often, breaking ties by choosing the
+// smallest value so that the result is deterministic. It returns an empty
+// string if no values were seen.
+func (v votes) winner() string {
+	best := ""
+	for value, n := range v {
+		if best == "" || n > v[best] || (n == v[best] && value < best) {
+			best = value
+		}
+	}
+	return best
+}
+
+// Languages maps file extensions, such as ".go", and whole file names, such
+// as "Makefile", to the language tags used by DetectLanguages. It can be
+// modified to support more languages or different tags, as long as that is
+// done before any concurrent use of DetectLanguages.
+var Languages = map[string][]string{
+	".bash":       {"shell", "bash"},
+	".c":          {"c"},
+	".cc":         {"cpp"},
+	".cpp":        {"cpp"},
+	".css":        {"css"},
+	".go":         {"go"},
+	".h":          {"c"},
+	".hpp":        {"cpp"},
+	".html":       {"html"},
+	".java":       {"java"},
+	".js":         {"javascript"},
+	".json":       {"json"},
+	".md":         {"markdown"},
+	".mk":         {"make"},
+	".py":         {"python"},
+	".rb":         {"ruby"},
+	".rs":         {"rust"},
+	".sh":         {"shell"},
+	".toml":       {"toml"},
+	".ts":         {"typescript"},
+	".yaml":       {"yaml"},
+	".yml":        {"yaml"},
+	"Dockerfile":  {"dockerfile"},
+	"GNUmakefile": {"make"},
+	"Makefile":    {"make"},
+	"go.mod":      {"gomod"},
+}
+
+// DetectLanguages returns the language tags for a file given its name, as per
+// the Languages table, which can be passed to Find or File.Filter to match
+// sections such as "[[go]]". Whole file names take precedence over
+// extensions, which are matched regardless of case. If the language is not
+// known, it returns nil.
+func DetectLanguages(name string) []string {
+	base := filepath.Base(name)
+	langs, ok := Languages[base]
+	if !ok {
+		langs = Languages[strings.ToLower(filepath.Ext(base))]
+	}
+	if len(langs) == 0 {
+		return nil
+	}
+	return append([]string(nil), langs...)
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2019, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package editorconfig
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectIndent(t *testing.T) {
+	tests := []struct {
+		in    string
+		style IndentStyle
+		size  int
+		ok    bool
+	}{
+		{"", IndentUnset, 0, false},
+		{"foo\nbar\n", IndentUnset, 0, false},
+		{"func f() {\n\tif x {\n\t\ty()\n\t}\n}\n", IndentTab, 0, true},
+		{"def f():\n    if x:\n        y()\n    return\n", IndentSpace, 4, true},
+		{"a:\n  b:\n    c: d\n  e: f\n\n  g:\n    h: i\n", IndentSpace, 2, true},
+		{"a\n\tb\n  c\n", IndentUnset, 0, false},
+		{"a\n  b\nc\n    d\n", IndentUnset, 0, false},
+	}
+	for _, tc := range tests {
+		style, size, ok := DetectIndent([]byte(tc.in))
+		if style != tc.style || size != tc.size || ok != tc.ok {
+			t.Errorf("DetectIndent(%q): want (%q, %d, %t), got (%q, %d, %t)",
+				tc.in, tc.style, tc.size, tc.ok, style, size, ok)
+		}
+	}
+}
+
+func TestDetectLanguages(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"main.go", []string{"go"}},
+		{filepath.Join("sub", "MAIN.GO"), []string{"go"}},
+		{"script.bash", []string{"shell", "bash"}},
+		{"Makefile", []string{"make"}},
+		{filepath.Join("sub", "go.mod"), []string{"gomod"}},
+		{"notes.txt", nil},
+		{"LICENSE", nil},
+	}
+	for _, tc := range tests {
+		if got := DetectLanguages(tc.name); fmt.Sprint(got) != fmt.Sprint(tc.want) || (got == nil) != (tc.want == nil) {
+			t.Errorf("DetectLanguages(%q): want %q, got %q", tc.name, tc.want, got)
+		}
+	}
+
+	// The table can be extended, and the results are copies.
+	Languages[".templ"] = []string{"templ"}
+	defer delete(Languages, ".templ")
+	langs := DetectLanguages("page.templ")
+	if fmt.Sprint(langs) != "[templ]" {
+		t.Fatalf("want the added language, got %q", langs)
+	}
+	langs[0] = "changed"
+	if got := Languages[".templ"][0]; got != "templ" {
+		t.Fatalf("modifying the result changed the table to %q", got)
+	}
+}
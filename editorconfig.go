@@ -7,21 +7,43 @@ package editorconfig
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 )
 
 const DefaultName = ".editorconfig"
 
+// ConfigNameEnv is the environment variable which QueryFromEnv reads the
+// EditorConfig file name from, allowing users to override DefaultName for all
+// the tools which support it.
+const ConfigNameEnv = "EDITORCONFIG_NAME"
+
 // File is an EditorConfig file with a number of sections.
 type File struct {
+	// Comment holds the comment lines at the top of the file, preceding
+	// the root property if there is one, in the same format as
+	// Section.Comment. It can be used to describe generated files.
+	Comment string
+
 	Root     bool
 	Sections []Section
+
+	// preamble holds the properties found before the first section when
+	// parsing, including any ignored ones, for the sake of Validate.
+	preamble []Property
 }
 
 // Section is a single EditorConfig section, which applies a number of
@@ -29,16 +51,42 @@ type File struct {
 type Section struct {
 	// Name is the section's name. Usually, this will be a valid pattern
 	// matching string, such as "[*.go]" without the square brackets.
+	// Special characters can be matched literally by escaping them with a
+	// backslash, such as "[foo\*bar]" to match a literal asterisk.
 	//
-	// It may also describe a language such as "[[shell]]",
-	// although this is an out-of-spec feature that may be changed at any time.
+	// It may also describe a language such as "[[shell]]"; see IsLanguage.
+	// This is an out-of-spec feature that may be changed at any time.
 	Name string
 
+	// Language, if non-empty, restricts the section to the files of a
+	// language which also match Name as a pattern, such as the header
+	// "[[go]] *_test.go" with Language "go" and Name "*_test.go".
+	// Such a Name may not start with "#" or ";", as it would look like a
+	// comment after the header.
+	// This is an out-of-spec feature that may be changed at any time.
+	Language string
+
+	// Comment holds the comment lines preceding the section's header,
+	// separated by newlines, if comments were kept when parsing.
+	// Lines which don't start with "#" or ";" are printed as comments
+	// following PrintOptions.CommentPrefix, and empty lines are printed as
+	// blank lines.
+	Comment string
+
 	// Properties is the list of name-value properties contained by a
 	// section. It is kept in increasing order, to allow binary searches.
+	//
+	// Methods such as Add keep this invariant; code which modifies the
+	// slice directly must keep it as well.
 	Properties []Property
 
-	// TODO: properties are not actually kept in increasing order
+	// rx is the compiled pattern for rxName, set by File.Compile.
+	rx     *regexp.Regexp
+	rxName string
+
+	// order holds the property names in the order they were parsed in,
+	// if ParseOptions.KeepOrder was used.
+	order []string
 }
 
 // Property is a single property with a name and a value, which can be
@@ -48,27 +96,288 @@ type Property struct {
 	Name string
 	// Value holds data for a property.
 	Value string
+	// Comment holds the comment lines preceding the property, in the same
+	// format as Section.Comment.
+	Comment string
 }
 
 // String turns a property into its INI format.
 func (p Property) String() string { return fmt.Sprintf("%s=%s", p.Name, p.Value) }
 
-// String turns a file into its INI format.
+// String turns a file into its INI format. Sections and properties which
+// cannot be printed, as described in PrintOptions.Print, are omitted; use
+// WriteTo or MarshalText to get an error for them instead.
 func (f *File) String() string {
 	var b strings.Builder
+	f.WriteTo(&b)
+	return b.String()
+}
+
+// GoString returns a Go expression for a file, implementing fmt.GoStringer.
+// Fields with zero values are omitted, as are unexported fields such as
+// compiled patterns, which makes "%#v" useful when debugging or testing.
+func (f *File) GoString() string {
+	if f == nil {
+		return "(*editorconfig.File)(nil)"
+	}
+	var fields []string
+	if f.Comment != "" {
+		fields = append(fields, fmt.Sprintf("Comment:%q", f.Comment))
+	}
 	if f.Root {
-		fmt.Fprintf(&b, "root=true\n\n")
+		fields = append(fields, "Root:true")
 	}
-	for i, section := range f.Sections {
-		if i > 0 {
-			fmt.Fprintln(&b)
+	if f.Sections != nil {
+		fields = append(fields, fmt.Sprintf("Sections:%#v", f.Sections))
+	}
+	return "&editorconfig.File{" + strings.Join(fields, ", ") + "}"
+}
+
+// WriteTo writes a file in its INI format to w, implementing io.WriterTo.
+//
+// It is equivalent to PrintOptions{}.Print.
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	return PrintOptions{}.Print(w, f)
+}
+
+// PrintOptions allows fine-grained control of how files are printed in their
+// INI format.
+type PrintOptions struct {
+	// CommentPrefix is used to start the comment lines which don't already
+	// start with "#" or ";", such as those set by a program. It should be
+	// "#" or ";"; if empty, it defaults to "#".
+	CommentPrefix string
+
+	// NoFinalNewline omits the newline which otherwise ends the output.
+	NoFinalNewline bool
+
+	// CanonicalOrder prints the properties defined by the spec in the order
+	// they are conventionally listed in, starting with indent_style and
+	// ending with insert_final_newline, followed by any others sorted by
+	// name. It takes precedence over the order kept by
+	// ParseOptions.KeepOrder.
+	CanonicalOrder bool
+}
+
+// canonicalOrder is the conventional order of the properties defined by the
+// spec, used by PrintOptions.CanonicalOrder.
+var canonicalOrder = []string{
+	"indent_style",
+	"indent_size",
+	"tab_width",
+	"end_of_line",
+	"charset",
+	"trim_trailing_whitespace",
+	"insert_final_newline",
+}
+
+// Print writes a file in its INI format to w as configured by the options,
+// returning the number of bytes written.
+//
+// Each line ends with a newline, including the last one, and sections are
+// separated from each other and from the top of the file by a blank line.
+// The output of an empty file is empty.
+//
+// The INI format has no way to escape characters, so sections and properties
+// which would not parse back the same are omitted, and an error is returned
+// after printing the rest of the file. This is the case for sections whose
+// name is empty, contains a newline, or would not be parsed as the same
+// header, for property names which are not lowercase or which contain
+// separators, and for values containing newlines or with leading or trailing
+// whitespace. Comments are printed as they are.
+func (o PrintOptions) Print(w io.Writer, f *File) (int64, error) {
+	if o.NoFinalNewline {
+		var b bytes.Buffer
+		o.NoFinalNewline = false
+		_, printErr := o.Print(&b, f)
+		n, err := w.Write(bytes.TrimSuffix(b.Bytes(), []byte("\n")))
+		if err == nil {
+			err = printErr
 		}
-		fmt.Fprintf(&b, "[%s]\n", section.Name)
-		for _, prop := range section.Properties {
-			fmt.Fprintf(&b, "%s=%s\n", prop.Name, prop.Value)
+		return int64(n), err
+	}
+	p := printer{w: w, opts: o}
+	p.comment(f.Comment)
+	if f.Root {
+		p.printf("root=true\n")
+	}
+	separate := f.Comment != "" || f.Root
+	for _, section := range f.Sections {
+		if err := checkPrintableHeader(section); err != nil {
+			p.skipped(err)
+			continue
 		}
+		if separate {
+			p.printf("\n")
+		}
+		separate = true
+		p.comment(section.Comment)
+		p.printf("%s\n", section.header())
+		p.properties(section)
 	}
-	return b.String()
+	if p.err == nil {
+		p.err = p.skipErr
+	}
+	return p.n, p.err
+}
+
+// MarshalText encodes a file in its INI format, implementing
+// encoding.TextMarshaler.
+func (f *File) MarshalText() ([]byte, error) {
+	var b bytes.Buffer
+	if _, err := f.WriteTo(&b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// UnmarshalText parses a file via Parse, implementing
+// encoding.TextUnmarshaler.
+func (f *File) UnmarshalText(text []byte) error {
+	parsed, err := Parse(bytes.NewReader(text))
+	if err != nil {
+		return err
+	}
+	*f = *parsed
+	return nil
+}
+
+// printer writes the INI format to a writer, keeping track of the number of
+// bytes written and the first error encountered.
+type printer struct {
+	w       io.Writer
+	opts    PrintOptions
+	n       int64
+	err     error
+	skipErr error // the first section or property which could not be printed
+}
+
+// skipped records that a section or property could not be printed.
+func (p *printer) skipped(err error) {
+	if p.skipErr == nil {
+		p.skipErr = err
+	}
+}
+
+func (p *printer) printf(format string, args ...any) {
+	if p.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(p.w, format, args...)
+	p.n += int64(n)
+	p.err = err
+}
+
+func (p *printer) comment(comment string) {
+	if comment == "" {
+		return
+	}
+	for _, line := range strings.Split(comment, "\n") {
+		if line != "" && line[0] != '#' && line[0] != ';' {
+			prefix := p.opts.CommentPrefix
+			if prefix == "" {
+				prefix = "#"
+			}
+			p.printf("%s %s\n", prefix, line)
+		} else {
+			p.printf("%s\n", line)
+		}
+	}
+}
+
+func (p *printer) properties(s Section) {
+	order := s.order
+	if p.opts.CanonicalOrder {
+		order = canonicalOrder
+	}
+	if len(order) == 0 {
+		for _, prop := range s.Properties {
+			p.property(prop)
+		}
+		return
+	}
+	// Print the properties in the given order first, followed by any
+	// others, such as those added since parsing.
+	printed := make(map[string]bool, len(s.Properties))
+	for _, name := range order {
+		if prop := s.Lookup(name); prop != nil && !printed[name] {
+			p.property(*prop)
+			printed[name] = true
+		}
+	}
+	for _, prop := range s.Properties {
+		if !printed[prop.Name] {
+			p.property(prop)
+		}
+	}
+}
+
+func (p *printer) property(prop Property) {
+	if err := checkPrintable(prop); err != nil {
+		p.skipped(err)
+		return
+	}
+	p.comment(prop.Comment)
+	p.printf("%s=%s\n", prop.Name, prop.Value)
+}
+
+// checkPrintableHeader returns an error if a section's header would not be
+// parsed back the same after being printed.
+func checkPrintableHeader(s Section) error {
+	header := s.header()
+	switch name, lang := sectionHeader(header); {
+	case s.Name == "" && s.Language == "":
+		return fmt.Errorf("cannot print a section with an empty name")
+	case strings.ContainsAny(header, "\r\n"):
+		return fmt.Errorf("cannot print section %q: name contains a newline", s.Name)
+	case s.Language != "" && strings.IndexAny(s.Name, "#;") == 0:
+		return fmt.Errorf("cannot print section %q: pattern starts like a comment", s.Name)
+	case name != s.Name || lang != s.Language:
+		return fmt.Errorf("cannot print section %q: header %s would not parse back the same", s.Name, header)
+	}
+	return nil
+}
+
+// checkPrintable returns an error if a property would not be parsed back the
+// same after being printed.
+func checkPrintable(prop Property) error {
+	name, value := prop.Name, prop.Value
+	switch {
+	case name != strings.ToLower(name):
+		return fmt.Errorf("cannot print property %q: name is not lowercase", name)
+	case strings.ContainsAny(name, "=:\r\n"):
+		return fmt.Errorf("cannot print property %q: name contains a separator or newline", name)
+	case name != "" && (name[0] == '#' || name[0] == ';'):
+		return fmt.Errorf("cannot print property %q: name starts like a comment", name)
+	case isSectionHeader(prop.String()):
+		return fmt.Errorf("cannot print property %q: it looks like a section header", name)
+	case strings.TrimSpace(name) != name:
+		return fmt.Errorf("cannot print property %q: name has leading or trailing whitespace", name)
+	case strings.ContainsAny(value, "\r\n"):
+		return fmt.Errorf("cannot print property %q: value contains a newline", name)
+	case strings.TrimSpace(value) != value:
+		return fmt.Errorf("cannot print property %q: value has leading or trailing whitespace", name)
+	}
+	return nil
+}
+
+// GoString returns a Go expression for a section, implementing fmt.GoStringer.
+// Like File.GoString, it omits zero and unexported fields.
+func (s Section) GoString() string {
+	var fields []string
+	if s.Name != "" {
+		fields = append(fields, fmt.Sprintf("Name:%q", s.Name))
+	}
+	if s.Language != "" {
+		fields = append(fields, fmt.Sprintf("Language:%q", s.Language))
+	}
+	if s.Comment != "" {
+		fields = append(fields, fmt.Sprintf("Comment:%q", s.Comment))
+	}
+	if s.Properties != nil {
+		fields = append(fields, fmt.Sprintf("Properties:%#v", s.Properties))
+	}
+	return "editorconfig.Section{" + strings.Join(fields, ", ") + "}"
 }
 
 // Lookup finds a property by its name within a section and returns a pointer to
@@ -76,17 +385,27 @@ func (f *File) String() string {
 //
 // Note that most of the time, Get should be used instead.
 func (s Section) Lookup(name string) *Property {
-	// TODO: binary search
-	for i, prop := range s.Properties {
-		if prop.Name == name {
-			return &s.Properties[i]
-		}
+	i := s.search(name)
+	if i < len(s.Properties) && s.Properties[i].Name == name {
+		return &s.Properties[i]
 	}
 	return nil
 }
 
+// search returns the index at which a property with the given name is, or
+// would be inserted to keep the properties in increasing order.
+func (s Section) search(name string) int {
+	return sort.Search(len(s.Properties), func(i int) bool {
+		return s.Properties[i].Name >= name
+	})
+}
+
 // Get returns the value of a property found by its name. If no such property
 // exists, an empty string is returned.
+//
+// Since properties are kept sorted by name, Get performs a binary search and
+// does not allocate, so it is cheap to call repeatedly, even in hot loops.
+// Lookup, GetOr, and Has behave the same way.
 func (s Section) Get(name string) string {
 	if prop := s.Lookup(name); prop != nil {
 		return prop.Value
@@ -94,12 +413,44 @@ func (s Section) Get(name string) string {
 	return ""
 }
 
+// GetOr is like Get, but it returns def if no such property exists.
+// Properties which exist with an empty value are returned as-is.
+func (s Section) GetOr(name, def string) string {
+	if prop := s.Lookup(name); prop != nil {
+		return prop.Value
+	}
+	return def
+}
+
+// Has reports whether a property exists with the given name, which allows
+// telling apart an unset property from one with an empty value.
+func (s Section) Has(name string) bool {
+	return s.Lookup(name) != nil
+}
+
+// Range calls fn for each of the section's properties in increasing order by
+// name, stopping early if fn returns false.
+func (s Section) Range(fn func(Property) bool) {
+	for _, prop := range s.Properties {
+		if !fn(prop) {
+			return
+		}
+	}
+}
+
 // IndentSize is a shortcut for Get("indent_size") as an int.
 func (s Section) IndentSize() int {
 	n, _ := strconv.Atoi(s.Get("indent_size"))
 	return n
 }
 
+// IndentSizeTab reports whether indent_size is set to the special "tab" value,
+// meaning that the indentation width is that of a tab. IndentSize returns 0 in
+// that case; see TabWidth for the width of a tab.
+func (s Section) IndentSizeTab() bool {
+	return s.Get("indent_size") == "tab"
+}
+
 // IndentSize is a shortcut for Get("trim_trailing_whitespace") as a bool.
 func (s Section) TrimTrailingWhitespace() bool {
 	return s.Get("trim_trailing_whitespace") == "true"
@@ -110,261 +461,1765 @@ func (s Section) InsertFinalNewline() bool {
 	return s.Get("insert_final_newline") == "true"
 }
 
-// IndentSize is similar to Get("indent_size"), but it handles the "tab" default
-// and returns an int. When unset, it returns 0.
+// TabWidth is similar to Get("tab_width"), but it returns an int. When
+// tab_width is unset, indent_size is used instead, unless it is "tab".
+// When neither is set, it returns 0.
 func (s Section) TabWidth() int {
-	value := s.Get("indent_size")
-	if value == "tab" {
-		value = s.Get("tab_width")
+	value := s.Get("tab_width")
+	if value == "" {
+		value = s.Get("indent_size")
 	}
 	n, _ := strconv.Atoi(value)
 	return n
 }
 
-// Add introduces a number of properties to the section. Properties that were
-// already part of the section are ignored.
+// EndOfLine returns the newline sequence described by Get("end_of_line"),
+// such as "\r\n" for "crlf". When unset or invalid, it returns an empty string.
+func (s Section) EndOfLine() string {
+	switch s.Get("end_of_line") {
+	case "lf":
+		return "\n"
+	case "crlf":
+		return "\r\n"
+	case "cr":
+		return "\r"
+	}
+	return ""
+}
+
+// Charset is a shortcut for Get("charset"), which is always lowercase when
+// parsed. See CharsetValid to check whether it's an allowed value.
+func (s Section) Charset() string {
+	return s.Get("charset")
+}
+
+// CharsetValid reports whether Charset is one of the values allowed by the
+// spec: "latin1", "utf-8", "utf-8-bom", "utf-16be", or "utf-16le".
+// An unset charset is not valid.
+func (s Section) CharsetValid() bool {
+	return validCharset(s.Charset())
+}
+
+func validCharset(charset string) bool {
+	switch charset {
+	case "latin1", "utf-8", "utf-8-bom", "utf-16be", "utf-16le":
+		return true
+	}
+	return false
+}
+
+// MaxLineLength is similar to Get("max_line_length"), but it handles the "off"
+// value and returns an int. The off result is true when the limit is
+// explicitly disabled, and ok is false when the property is unset or invalid.
+func (s Section) MaxLineLength() (n int, off bool, ok bool) {
+	value := s.Get("max_line_length")
+	if value == "off" {
+		return 0, true, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false, false
+	}
+	return n, false, true
+}
+
+// IndentStyle is a valid value for the indent_style property.
+type IndentStyle string
+
+const (
+	IndentUnset IndentStyle = ""
+	IndentTab   IndentStyle = "tab"
+	IndentSpace IndentStyle = "space"
+)
+
+// IndentStyleValue is a shortcut for Get("indent_style") as an IndentStyle.
+// When unset or invalid, it returns IndentUnset.
+func (s Section) IndentStyleValue() IndentStyle {
+	switch style := IndentStyle(s.Get("indent_style")); style {
+	case IndentTab, IndentSpace:
+		return style
+	}
+	return IndentUnset
+}
+
+// IndentText returns the whitespace making up one level of indentation:
+// a tab when indent_style is "tab", regardless of indent_size, or as many
+// spaces as indent_size when it is "space". When indent_style is unset, it
+// returns an empty string.
+func (s Section) IndentText() string {
+	switch s.IndentStyleValue() {
+	case IndentTab:
+		return "\t"
+	case IndentSpace:
+		return strings.Repeat(" ", max(s.IndentSize(), 0))
+	}
+	return ""
+}
+
+// Add introduces a number of properties to the section, inserting each of them
+// in increasing order by name. Properties that were already part of the
+// section are ignored.
 func (s *Section) Add(properties ...Property) {
 	for _, prop := range properties {
-		if s.Lookup(prop.Name) == nil {
-			s.Properties = append(s.Properties, prop)
+		i := s.search(prop.Name)
+		if i < len(s.Properties) && s.Properties[i].Name == prop.Name {
+			continue
+		}
+		s.insert(i, prop)
+	}
+}
+
+// Set introduces a property to the section, replacing the value of an existing
+// property with the same name if there is one.
+func (s *Section) Set(prop Property) {
+	i := s.search(prop.Name)
+	if i < len(s.Properties) && s.Properties[i].Name == prop.Name {
+		s.Properties[i].Value = prop.Value
+		return
+	}
+	s.insert(i, prop)
+}
+
+func (s *Section) insert(i int, prop Property) {
+	s.Properties = append(s.Properties, Property{})
+	copy(s.Properties[i+1:], s.Properties[i:])
+	s.Properties[i] = prop
+}
+
+// Remove deletes the property with the given name from the section, if it
+// exists. It reports whether a property was removed.
+func (s *Section) Remove(name string) bool {
+	i := s.search(name)
+	if i >= len(s.Properties) || s.Properties[i].Name != name {
+		return false
+	}
+	s.Properties = append(s.Properties[:i], s.Properties[i+1:]...)
+	return true
+}
+
+// Clone returns a deep copy of the section, so that modifying either section
+// does not affect the other. The copy's pattern is compiled again if needed.
+func (s Section) Clone() Section {
+	s.Properties = append([]Property(nil), s.Properties...)
+	s.order = append([]string(nil), s.order...)
+	s.rx, s.rxName = nil, ""
+	return s
+}
+
+// PropertyChange is a difference between two sections found by Section.Diff.
+// Old is empty if the property was added, and New is empty if it was removed.
+type PropertyChange struct {
+	Name     string
+	Old, New string
+}
+
+// Diff returns the properties which were added, removed, or modified in other
+// when compared to s, sorted by name.
+func (s Section) Diff(other Section) []PropertyChange {
+	var changes []PropertyChange
+	a, b := s.Properties, other.Properties
+	for len(a) > 0 || len(b) > 0 {
+		switch {
+		case len(b) == 0 || (len(a) > 0 && a[0].Name < b[0].Name):
+			changes = append(changes, PropertyChange{Name: a[0].Name, Old: a[0].Value})
+			a = a[1:]
+		case len(a) == 0 || b[0].Name < a[0].Name:
+			changes = append(changes, PropertyChange{Name: b[0].Name, New: b[0].Value})
+			b = b[1:]
+		default:
+			if a[0].Value != b[0].Value {
+				changes = append(changes, PropertyChange{Name: a[0].Name, Old: a[0].Value, New: b[0].Value})
+			}
+			a, b = a[1:], b[1:]
+		}
+	}
+	return changes
+}
+
+// Equal reports whether two sections have the same name, language, and
+// properties. Comments are ignored, as is any state such as compiled
+// patterns. Since properties are kept sorted by name, their order does not
+// matter.
+func (s Section) Equal(other Section) bool {
+	if s.Name != other.Name || s.Language != other.Language || len(s.Properties) != len(other.Properties) {
+		return false
+	}
+	for i, prop := range s.Properties {
+		if prop.Name != other.Properties[i].Name || prop.Value != other.Properties[i].Value {
+			return false
 		}
 	}
+	return true
 }
 
-// String turns a section into its INI format.
+// String turns a section into its INI format. Its header is omitted if the
+// section has no name, such as those returned by Find. A header and properties
+// which cannot be printed, as described in PrintOptions.Print, are omitted.
 func (s Section) String() string {
 	var b strings.Builder
-	if s.Name != "" {
-		fmt.Fprintf(&b, "[%s]\n", s.Name)
+	p := printer{w: &b}
+	if s.Name != "" || s.Language != "" {
+		if checkPrintableHeader(s) == nil {
+			p.comment(s.Comment)
+			p.printf("%s\n", s.header())
+		}
 	}
+	p.properties(s)
+	return b.String()
+}
+
+// jsonSection is the JSON representation of a section, with its properties
+// encoded as an object keyed by name.
+type jsonSection struct {
+	Name       string            `json:"name,omitempty"`
+	Language   string            `json:"language,omitempty"`
+	Properties map[string]string `json:"properties"`
+}
+
+// MarshalJSON encodes a section as a JSON object such as
+// {"name":"*.go","properties":{"indent_style":"tab"}}, implementing
+// json.Marshaler. Comments are not included.
+func (s Section) MarshalJSON() ([]byte, error) {
+	js := jsonSection{Name: s.Name, Language: s.Language, Properties: make(map[string]string, len(s.Properties))}
 	for _, prop := range s.Properties {
-		fmt.Fprintf(&b, "%s=%s\n", prop.Name, prop.Value)
+		js.Properties[prop.Name] = prop.Value
 	}
-	return b.String()
+	return json.Marshal(js)
+}
+
+// UnmarshalJSON decodes a section in the format produced by MarshalJSON,
+// implementing json.Unmarshaler.
+func (s *Section) UnmarshalJSON(data []byte) error {
+	var js jsonSection
+	if err := json.Unmarshal(data, &js); err != nil {
+		return err
+	}
+	*s = Section{Name: js.Name, Language: js.Language}
+	for name, value := range js.Properties {
+		s.Add(Property{Name: name, Value: value})
+	}
+	return nil
 }
 
 // Filter returns the set of properties in f which apply to a file
 // given its name and optional languages.
-// Properties from later sections take precedence. The name should be a path
-// relative to the directory holding the EditorConfig.
+// Properties from later sections take precedence, and the resulting
+// properties are sorted by name regardless of which sections they came from.
+// The name should be a path relative to the directory holding the
+// EditorConfig, using either forward slashes or the host's path separator,
+// such as backslashes on Windows. See RelName to obtain such a name from a
+// path.
 //
 // If cache is non-nil, the map will be used to reuse patterns translated and
 // compiled to regular expressions.
 //
+// Properties whose value is "unset" in the section which takes precedence are
+// removed from the result, as per the spec.
+//
+// Sections whose name is not a valid pattern never match; see
+// Section.MatchError to find such errors.
+//
 // Note that this function doesn't apply defaults; for that, see Find.
 //
-// Note that, since the EditorConfig spec doesn't allow backslashes as path
-// separators, backslashes in name are converted to forward slashes.
+// Note that backslashes in name are only treated as path separators on hosts
+// which use them, such as Windows. Elsewhere, they are part of the file name.
 func (f *File) Filter(name string, languages []string, cache map[string]*regexp.Regexp) Section {
+	result, _ := f.filter(name, languages, matcher{cache: mapCache{regexps: cache}}, nil)
+	result.removeUnset()
+	return result
+}
+
+// RelName returns the name of a file relative to the directory holding an
+// EditorConfig file, using forward slashes, as expected by File.Filter and
+// Section.MatchError. Both paths must be either absolute or relative to the
+// same directory. An error is returned if the file is not within configDir.
+func RelName(configDir, filePath string) (string, error) {
+	rel, err := filepath.Rel(configDir, filePath)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not within %s", filePath, configDir)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// MatchingSections returns the sections in f which apply to a file given its
+// name and optional languages, in the order they appear in f. This is also
+// their order of precedence, so the last section takes precedence over the
+// others. Sections whose name is not a valid pattern never match.
+// The name is interpreted like in File.Filter.
+func (f *File) MatchingSections(name string, languages []string) []*Section {
+	name = filepath.ToSlash(name)
+	m := matcher{cache: mapCache{}}
+	var sections []*Section
+	for i := range f.Sections {
+		if ok, _ := f.Sections[i].match(name, languages, m); ok {
+			sections = append(sections, &f.Sections[i])
+		}
+	}
+	return sections
+}
+
+// PropertyNames returns the names of the properties set by any of the file's
+// sections, sorted and without duplicates.
+func (f *File) PropertyNames() []string {
+	n := 0
+	for _, section := range f.Sections {
+		n += len(section.Properties)
+	}
+	if n == 0 {
+		return nil
+	}
+	names := make([]string, 0, n)
+	for _, section := range f.Sections {
+		for _, prop := range section.Properties {
+			names = append(names, prop.Name)
+		}
+	}
+	sort.Strings(names)
+	unique := names[:1]
+	for _, name := range names[1:] {
+		if name != unique[len(unique)-1] {
+			unique = append(unique, name)
+		}
+	}
+	return unique
+}
+
+// filter is like Filter, but it keeps properties with the "unset" value, so
+// that they can still take precedence over other files when merging.
+// It also returns the first error found when compiling section patterns.
+//
+// If origins is non-nil, it records the index of the section which each of the
+// resulting properties came from.
+func (f *File) filter(name string, languages []string, m matcher, origins map[string]int) (Section, error) {
 	name = filepath.ToSlash(name)
 	result := Section{}
+	var firstErr error
 	for i := len(f.Sections) - 1; i >= 0; i-- {
 		section := f.Sections[i]
-
-		if len(section.Name) > 2 && section.Name[0] == '[' && section.Name[len(section.Name)-1] == ']' {
-			sectionLang := section.Name[1 : len(section.Name)-1]
-			for _, language := range languages {
-				if language == sectionLang {
-					result.Add(section.Properties...)
-					break
-				}
+		matched, err := section.match(name, languages, m)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
 			}
 			continue
 		}
-
-		rx := cache[section.Name]
-		if rx == nil {
-			rx = toRegexp(section.Name)
-			if cache != nil {
-				cache[section.Name] = rx
-			}
+		if !matched {
+			continue
 		}
-		if rx.MatchString(name) {
-			result.Add(section.Properties...)
+		if origins != nil {
+			for _, prop := range section.Properties {
+				if !result.Has(prop.Name) {
+					origins[prop.Name] = i
+				}
+			}
 		}
+		result.Add(section.Properties...)
 	}
-	return result
+	return result, firstErr
 }
 
-// Find figures out the properties that apply to a file name on disk, and
-// returns them as a section. The name doesn't need to be an absolute path.
+// MatchError reports whether the section's pattern matches a file name,
+// which should be a path relative to the directory holding the EditorConfig.
+// As with File.Filter, the host's path separator is supported, so that
+// "sub\main.go" matches "sub/*.go" on Windows.
+// Language sections such as "[[go]]" never match.
 //
-// It is equivalent to Query{}.Find; please note that no caching at all takes
-// place in this mode.
-func Find(name string, languages []string) (Section, error) {
-	return Query{}.Find(name, languages)
+// If the section's name is not a valid pattern, an error is returned.
+func (s Section) MatchError(name string) (bool, error) {
+	return s.match(filepath.ToSlash(name), nil, matcher{cache: mapCache{}})
 }
 
-// Query allows fine-grained control of how EditorConfig files are found and
-// used. It also attempts to cache and reuse work, which makes its Find method
-// significantly faster when used on many files.
-type Query struct {
-	// ConfigName specifies what EditorConfig file name to use when
-	// searching for files on disk. If empty, it defaults to DefaultName.
-	ConfigName string
-
-	// FileCache keeps track of which directories are known to contain an
-	// EditorConfig. Existing entries which are nil mean that the directory
-	// is known to not contain an EditorConfig.
-	//
-	// If nil, no caching takes place.
-	FileCache map[string]*File
-
-	// RegexpCache keeps track of patterns which have already been
-	// translated to a regular expression and compiled, to save repeating
-	// the work.
-	//
-	// If nil, no caching takes place.
-	RegexpCache map[string]*regexp.Regexp
+// IsLanguage returns the language tag of a section such as "[[go]]", whose
+// name is "[go]", and whether the section is for a language at all. Rather
+// than matching file names with a pattern, a language section applies to a
+// file when its tag is one of the languages given to Find or File.Filter.
+//
+// A language section may also have a pattern, such as "[[go]] *_test.go",
+// in which case the tag is held in the Language field and the pattern in
+// Name. It then only applies to the files of that language which also match
+// the pattern.
+//
+// A name is only a language tag if the brackets contain no other brackets,
+// so that a pattern like "[[:digit:]].txt" is not mistaken for one.
+func (s Section) IsLanguage() (string, bool) {
+	lang, _, ok := s.splitLanguage()
+	return lang, ok
+}
 
-	// Version specifies an EditorConfig version to use when applying its
-	// spec. When empty, it defaults to the latest version. This field
-	// should generally be left untouched.
-	Version string
+// splitLanguage splits a section's name into its language tag and pattern.
+// If the section is not for a language, the pattern is the entire name.
+// If it is for a language without a pattern, the pattern is empty.
+func (s Section) splitLanguage() (lang, pattern string, ok bool) {
+	if s.Language != "" {
+		return s.Language, s.Name, true
+	}
+	name := s.Name
+	if len(name) > 2 && name[0] == '[' && name[len(name)-1] == ']' {
+		if lang := name[1 : len(name)-1]; !strings.ContainsAny(lang, "[]") {
+			return lang, "", true
+		}
+	}
+	return "", name, false
 }
 
-// Find figures out the properties that apply to a file on disk
-// given its name and languages, returns them as a section.
-// The name doesn't need to be an absolute path.
-//
-// Any relevant EditorConfig files are parsed and used as necessary. Parsing the
-// files can be cached in Query.
-//
-// The defaults for supported properties are applied before returning.
-func (q Query) Find(name string, languages []string) (Section, error) {
-	name, err := filepath.Abs(name)
-	if err != nil {
-		return Section{}, err
+// header returns the section's header line without its newline, such as
+// "[*.go]" or "[[go]] *_test.go".
+func (s Section) header() string {
+	switch {
+	case s.Language == "":
+		return "[" + s.Name + "]"
+	case s.Name == "":
+		return "[[" + s.Language + "]]"
 	}
-	configName := q.ConfigName
-	if configName == "" {
-		configName = DefaultName
+	return "[[" + s.Language + "]] " + s.Name
+}
+
+// key returns a string identifying the section's header, as used by
+// Normalize to find sections sharing the same header.
+func (s Section) key() string {
+	if s.Language == "" {
+		return s.Name
 	}
+	return s.header()
+}
 
-	result := Section{}
-	dir := name
-	for {
-		if d := filepath.Dir(dir); d != dir {
-			dir = d
-		} else {
-			break
+// matcher holds the options used when matching sections against file names.
+type matcher struct {
+	cache Cache
+	fold  bool // match case-insensitively
+}
+
+func (s Section) match(name string, languages []string, m matcher) (bool, error) {
+	sectionLang, pattern, ok := s.splitLanguage()
+	if ok {
+		found := false
+		for _, language := range languages {
+			if language == sectionLang {
+				found = true
+				break
+			}
+		}
+		if !found || pattern == "" {
+			return found, nil
 		}
-		file, e := q.FileCache[dir]
-		if !e {
-			// TODO: replace with io/fs
-			f, err := os.Open(filepath.Join(dir, configName))
-			if os.IsNotExist(err) {
-				// continue below, caching the nil file
-			} else if err != nil {
-				return Section{}, err
+	}
+
+	if s.rx != nil && s.rxName == s.Name && !m.fold {
+		return s.rx.MatchString(name), nil
+	}
+	key := pattern
+	if m.fold {
+		// Case-insensitive regexps are cached separately.
+		// Section names don't contain null bytes in practice.
+		key = "\x00i" + key
+	}
+	rx, ok := m.cache.LoadRegexp(key)
+	if !ok {
+		var err error
+		if rx, err = toRegexp(pattern, m.fold); err != nil {
+			return false, err
+		}
+		m.cache.StoreRegexp(key, rx)
+	}
+	return rx.MatchString(name), nil
+}
+
+// Clone returns a deep copy of the file, as if each section was copied with
+// Section.Clone.
+func (f *File) Clone() *File {
+	clone := *f
+	clone.Sections = make([]Section, len(f.Sections))
+	for i, section := range f.Sections {
+		clone.Sections[i] = section.Clone()
+	}
+	return &clone
+}
+
+// Merge returns a new file with the sections of f followed by those of other,
+// so that the properties in other take precedence. The result is root if
+// either file is root, and it keeps the comment of f, or else that of other.
+//
+// The sections are copied, so modifying the result does not affect f or other.
+func (f *File) Merge(other *File) *File {
+	merged := &File{
+		Comment:  f.Comment,
+		Root:     f.Root || other.Root,
+		Sections: make([]Section, 0, len(f.Sections)+len(other.Sections)),
+	}
+	if merged.Comment == "" {
+		merged.Comment = other.Comment
+	}
+	// Keep the properties before the first section for Validate, like
+	// the comment.
+	merged.preamble = slices.Clone(f.preamble)
+	if len(merged.preamble) == 0 {
+		merged.preamble = slices.Clone(other.preamble)
+	}
+	for _, sections := range [][]Section{f.Sections, other.Sections} {
+		for _, section := range sections {
+			section.Properties = append([]Property(nil), section.Properties...)
+			merged.Sections = append(merged.Sections, section)
+		}
+	}
+	return merged
+}
+
+// Normalize returns a new file where sections sharing the same name are
+// merged into the first of them, with properties from later sections taking
+// precedence. Sections otherwise keep their order.
+//
+// Note that this can change which properties apply to a file if a section
+// between two merged sections matches it too, as precedence depends on the
+// order of sections.
+func (f *File) Normalize() *File {
+	norm := &File{Comment: f.Comment, Root: f.Root, preamble: slices.Clone(f.preamble)}
+	index := make(map[string]int, len(f.Sections))
+	for _, section := range f.Sections {
+		i, ok := index[section.key()]
+		if !ok {
+			index[section.key()] = len(norm.Sections)
+			section.Properties = append([]Property(nil), section.Properties...)
+			norm.Sections = append(norm.Sections, section)
+			continue
+		}
+		for _, prop := range section.Properties {
+			norm.Sections[i].Set(prop)
+		}
+	}
+	return norm
+}
+
+// Compile translates and compiles the patterns of all the file's sections up
+// front, returning the first error for an invalid pattern. Filter can then
+// match the sections without a cache, so the file can be shared by many
+// goroutines as long as it is not modified.
+//
+// A section whose name is changed after compiling falls back to compiling its
+// pattern when matching.
+func (f *File) Compile() error {
+	var firstErr error
+	for i := range f.Sections {
+		if _, err := f.Sections[i].Regexp(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Regexp returns the regular expression which the section's pattern is
+// translated to, which matches slash-separated paths relative to the directory
+// holding the EditorConfig file. It is compiled the first time, as if by
+// File.Compile, and an error is returned if the pattern is invalid.
+//
+// Sections for a language without a pattern, such as "[[go]]", have no
+// regular expression, so Regexp returns nil for them.
+func (s *Section) Regexp() (*regexp.Regexp, error) {
+	_, pattern, _ := s.splitLanguage()
+	if pattern == "" {
+		return nil, nil
+	}
+	if s.rx != nil && s.rxName == s.Name {
+		return s.rx, nil
+	}
+	rx, err := toRegexp(pattern, false)
+	if err != nil {
+		return nil, err
+	}
+	s.rx, s.rxName = rx, s.Name
+	return rx, nil
+}
+
+// Specificity returns a score of how specific the section's pattern is, which
+// is the number of characters it matches literally. Wildcards such as "*",
+// "?", and "[a-z]" don't add to the score, and a brace expansion like
+// "{go,mod}" adds the score of its least specific alternative.
+// Language sections score as their pattern, so "[[go]]" scores zero.
+//
+// The score is only informational, such as to explain why a section took
+// precedence over another: EditorConfig resolves properties in the order the
+// sections appear in, so that later sections take precedence regardless of
+// their specificity.
+func (s Section) Specificity() int {
+	_, pattern, _ := s.splitLanguage()
+	return specificity(pattern)
+}
+
+func specificity(pat string) int {
+	score := 0
+	for i := 0; i < len(pat); i++ {
+		switch c := pat[i]; c {
+		case '\\':
+			i++
+			score++
+		case '*', '?':
+		case '[':
+			// Skip the class, which may start with a literal ']'.
+			j := i + 1
+			if j < len(pat) && (pat[j] == '!' || pat[j] == '^') {
+				j++
+			}
+			if j < len(pat) && pat[j] == ']' {
+				j++
+			}
+			if end := strings.IndexByte(pat[j:], ']'); end >= 0 {
+				i = j + end
 			} else {
-				var err error
-				file, err = Parse(f)
-				f.Close()
-				if err != nil {
-					return Section{}, err
+				score++
+			}
+		case '{':
+			alts, end := braceAlternatives(pat[i:])
+			if end < 0 || len(alts) < 2 {
+				if rxNumRange.MatchString(pat[i:]) {
+					// A numeric range matches at least one digit.
+					i += strings.IndexByte(pat[i:], '}')
 				}
+				score++
+				continue
 			}
-			if q.FileCache != nil {
-				q.FileCache[dir] = file
+			least := -1
+			for _, alt := range alts {
+				if n := specificity(alt); least < 0 || n < least {
+					least = n
+				}
 			}
+			score += least
+			i += end
+		default:
+			score++
 		}
-		if file == nil {
-			continue
+	}
+	return score
+}
+
+// braceAlternatives splits a brace expansion at the start of pat like
+// "{a,b{c,d}}" into its top-level alternatives, and returns the index of its
+// closing brace, or -1 if there is none.
+func braceAlternatives(pat string) (alts []string, end int) {
+	depth, start := 0, 1
+	for i := 0; i < len(pat); i++ {
+		switch pat[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case ',':
+			if depth == 1 {
+				alts = append(alts, pat[start:i])
+				start = i + 1
+			}
+		case '}':
+			if depth--; depth == 0 {
+				return append(alts, pat[start:i]), i
+			}
+		}
+	}
+	return nil, -1
+}
+
+// removeUnset drops all properties with the special "unset" value.
+func (s *Section) removeUnset() {
+	props := s.Properties[:0]
+	for _, prop := range s.Properties {
+		if prop.Value != "unset" {
+			props = append(props, prop)
+		}
+	}
+	s.Properties = props
+}
+
+// Find figures out the properties that apply to a file name on disk, and
+// returns them as a section. The name doesn't need to be an absolute path.
+//
+// It is equivalent to Query{}.Find; please note that no caching at all takes
+// place in this mode.
+func Find(name string, languages []string) (Section, error) {
+	return Query{}.Find(name, languages)
+}
+
+// Query allows fine-grained control of how EditorConfig files are found and
+// used. It also attempts to cache and reuse work, which makes its Find method
+// significantly faster when used on many files.
+type Query struct {
+	// ConfigName specifies what EditorConfig file name to use when
+	// searching for files on disk. If empty, it defaults to DefaultName.
+	ConfigName string
+
+	// FS, if non-nil, is the filesystem used to search for EditorConfig
+	// files instead of the host's. Names given to Find must then be valid
+	// paths as per fs.ValidPath, with the root of FS being the last
+	// directory searched.
+	//
+	// Since Go 1.24, an *os.Root can be used via its FS method, so that
+	// resolving untrusted names can't escape the root directory, not even
+	// via symbolic links.
+	FS fs.FS
+
+	// Open, if non-nil, is used to open EditorConfig files instead of
+	// os.Open or FS.Open, such as to add logging or read limits. Errors
+	// matching fs.ErrNotExist mean that a directory has no EditorConfig.
+	Open func(path string) (io.ReadCloser, error)
+
+	// FileCache keeps track of which directories are known to contain an
+	// EditorConfig. Existing entries which are nil mean that the directory
+	// is known to not contain an EditorConfig.
+	//
+	// If nil, no caching takes place.
+	FileCache map[string]*File
+
+	// RegexpCache keeps track of patterns which have already been
+	// translated to a regular expression and compiled, to save repeating
+	// the work.
+	//
+	// If nil, no caching takes place.
+	RegexpCache map[string]*regexp.Regexp
+
+	// Cache, if non-nil, is used instead of FileCache and RegexpCache.
+	// Unlike those maps, it can be safe for concurrent use, such as when
+	// using a SyncCache.
+	Cache Cache
+
+	// RootDir, if non-empty, is the last directory searched for EditorConfig
+	// files, as if it contained a file with "root = true". This prevents
+	// the search from leaking out of a directory tree, such as a repository.
+	RootDir string
+
+	// MaxDepth, if positive, is the maximum number of directories searched
+	// for EditorConfig files, starting with the one containing the file.
+	// Along with RootDir, it bounds the work done for each name, which can
+	// be useful when resolving untrusted names.
+	MaxDepth int
+
+	// CaseInsensitive makes section patterns match file names regardless of
+	// case, like "[*.go]" matching "Main.GO". This is useful on filesystems
+	// which are case-insensitive, such as those on macOS and Windows.
+	CaseInsensitive bool
+
+	// ResolveSymlinks makes Find resolve any symbolic links in the name
+	// before searching for EditorConfig files, so that the directories
+	// searched are those of the real file. RootDir and the directories
+	// given to Invalidate and Prewarm are resolved the same way.
+	// It has no effect when FS is set.
+	ResolveSymlinks bool
+
+	// StartDir, if non-empty, is the directory which relative names and
+	// RootDir are resolved against, instead of the current directory.
+	// This allows resolving names for many directory trees concurrently,
+	// without changing the process's working directory. It has no effect
+	// when FS is set.
+	StartDir string
+
+	// Defaults holds properties which apply to every file with the lowest
+	// precedence, so that they are only used when no EditorConfig file sets
+	// them. Setting a property to "unset" in a file also removes its default.
+	// The defaults for properties which depend on others, like indent_size,
+	// are applied afterwards, so they can depend on these.
+	Defaults []Property
+
+	// Strict makes Find parse EditorConfig files as with ParseOptions.Strict,
+	// so that it fails with an error mentioning the file's path if any of
+	// them is malformed, rather than ignoring the lines it can't understand.
+	// Files which were already cached are not parsed again.
+	Strict bool
+
+	// NoDefaults makes Find skip the defaults for supported properties
+	// which depend on others, such as indent_size; see ApplyDefaults.
+	// The properties in Defaults are still added.
+	NoDefaults bool
+
+	// Version specifies an EditorConfig version to use when applying its
+	// spec. When empty, it defaults to the latest version. This field
+	// should generally be left untouched.
+	Version string
+}
+
+// QueryFromEnv returns a Query configured from the environment. Its ConfigName
+// is read from the ConfigNameEnv variable, so it defaults to DefaultName when
+// the variable is unset or empty.
+func QueryFromEnv() Query {
+	return Query{ConfigName: os.Getenv(ConfigNameEnv)}
+}
+
+// Find figures out the properties that apply to a file on disk
+// given its name and languages, returns them as a section.
+// The name doesn't need to be an absolute path. When FS is set, the name must
+// be a path within it.
+//
+// The name always refers to a file, even if it is a directory on disk: the
+// search starts in the directory containing it, and its own name is matched
+// against section patterns but never searched for an EditorConfig file.
+// Trailing separators are ignored on the host's filesystem, while they are
+// invalid when FS is set.
+//
+// Any relevant EditorConfig files are parsed and used as necessary. Parsing the
+// files can be cached in Query.
+//
+// Properties set to "unset" by the configuration which takes precedence are
+// removed, and the defaults for supported properties are applied before
+// returning, unless NoDefaults is set.
+func (q Query) Find(name string, languages []string) (Section, error) {
+	return q.FindContext(context.Background(), name, languages)
+}
+
+// FindContext is like Find, but it stops searching for EditorConfig files
+// and returns the context's error once it is cancelled.
+func (q Query) FindContext(ctx context.Context, name string, languages []string) (Section, error) {
+	return q.find(ctx, name, languages, nil)
+}
+
+// Source records where a property resolved by Query.FindSources came from.
+type Source struct {
+	// Property is the name of the property.
+	Property string
+	// Path is the path to the EditorConfig file which set the property.
+	Path string
+	// Section is the index of the section within the file's Sections.
+	Section int
+}
+
+// FindSources is like Find, but it also returns where each of the resolved
+// properties came from, sorted by property name. Properties added as defaults
+// have no source.
+func (q Query) FindSources(name string, languages []string) (Section, []Source, error) {
+	sources := []Source{}
+	result, err := q.find(context.Background(), name, languages, &sources)
+	if err != nil {
+		return Section{}, nil, err
+	}
+	return result, sources, nil
+}
+
+// find implements FindContext, also recording sources if non-nil.
+func (q Query) find(ctx context.Context, name string, languages []string, sources *[]Source) (Section, error) {
+	result := Section{}
+	err := q.walk(ctx, name, func(dir, relative string, file *File) error {
+		var origins map[string]int
+		if sources != nil {
+			origins = make(map[string]int)
 		}
-		relative := name[len(dir)+1:]
-		result.Add(file.Filter(relative, languages, q.RegexpCache).Properties...)
-		if file.Root {
+		section, err := file.filter(relative, languages, matcher{q.cache(), q.CaseInsensitive}, origins)
+		if err != nil {
+			return err
+		}
+		for _, prop := range section.Properties {
+			if sources != nil && !result.Has(prop.Name) && prop.Value != "unset" {
+				*sources = append(*sources, Source{
+					Property: prop.Name,
+					Path:     q.configPath(dir),
+					Section:  origins[prop.Name],
+				})
+			}
+		}
+		result.Add(section.Properties...)
+		return nil
+	})
+	if err != nil {
+		return Section{}, err
+	}
+	result.Add(q.Defaults...)
+	result.removeUnset()
+	if sources != nil {
+		sort.Slice(*sources, func(i, j int) bool {
+			return (*sources)[i].Property < (*sources)[j].Property
+		})
+	}
+
+	if !q.NoDefaults {
+		result.applyDefaults(q.Version)
+	}
+	return result, nil
+}
+
+// textProperties are the properties which affect the contents of files,
+// as enforced by Section.Apply, along with charset.
+var textProperties = []string{"charset", "end_of_line", "insert_final_newline", "trim_trailing_whitespace"}
+
+// errAffects stops the walk in Query.Affects.
+var errAffects = errors.New("affects")
+
+// Affects reports whether a file is affected by any of the properties which
+// concern its contents: charset, end_of_line, insert_final_newline, and
+// trim_trailing_whitespace. It is like checking the result of Find for any of
+// them, but it stops as soon as one of them is found. Language sections are
+// not considered.
+func (q Query) Affects(name string) (bool, error) {
+	unset := make(map[string]bool)
+	err := q.walk(context.Background(), name, func(_, relative string, file *File) error {
+		section, err := file.filter(relative, nil, matcher{q.cache(), q.CaseInsensitive}, nil)
+		if err != nil {
+			return err
+		}
+		for _, prop := range textProperties {
+			if unset[prop] {
+				continue // a closer file takes precedence
+			}
+			switch value := section.Get(prop); value {
+			case "":
+			case "unset":
+				unset[prop] = true
+			default:
+				return errAffects
+			}
+		}
+		return nil
+	})
+	if err == errAffects {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	for _, prop := range q.Defaults {
+		if slices.Contains(textProperties, prop.Name) && !unset[prop.Name] && prop.Value != "unset" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ConfigFiles returns the paths of the EditorConfig files which Find would
+// use for a file, from the closest one to the furthest. Like Find, it stops
+// at a file with "root = true" or at RootDir. This can be useful to know
+// which files to watch for changes.
+func (q Query) ConfigFiles(name string) ([]string, error) {
+	var paths []string
+	err := q.walk(context.Background(), name, func(dir, _ string, _ *File) error {
+		paths = append(paths, q.configPath(dir))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// walk calls fn for each of the directories containing an EditorConfig file
+// which applies to name, from the closest to the furthest, along with the name
+// relative to the directory. The walk stops once a file has "root = true" or
+// RootDir is reached.
+func (q Query) walk(ctx context.Context, name string, fn func(dir, relative string, file *File) error) error {
+	dirFn, sep := filepath.Dir, string(filepath.Separator)
+	rootDir := q.RootDir
+	if q.FS != nil {
+		if !fs.ValidPath(name) {
+			return &fs.PathError{Op: "find", Path: name, Err: fs.ErrInvalid}
+		}
+		dirFn, sep = path.Dir, "/"
+		if rootDir != "" {
+			rootDir = path.Clean(rootDir)
+		}
+	} else {
+		var err error
+		if name, err = q.hostPath(name); err != nil {
+			return err
+		}
+		// RootDir is resolved like the name, so that they can be
+		// compared even when ResolveSymlinks is set.
+		if rootDir != "" {
+			if rootDir, err = q.hostPath(rootDir); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The name is never a directory to search, so the first directory
+	// searched is the one containing it.
+	dir := name
+	for depth := 1; q.MaxDepth <= 0 || depth <= q.MaxDepth; depth++ {
+		if d := dirFn(dir); d != dir {
+			dir = d
+		} else {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		file, err := q.load(dir)
+		if err != nil {
+			return err
+		}
+		if file != nil {
+			relative := name
+			if dir != "." {
+				relative = strings.TrimPrefix(name[len(dir):], sep)
+			}
+			if err := fn(dir, relative, file); err != nil {
+				return err
+			}
+			if file.Root {
+				break
+			}
+		}
+		if dir == rootDir {
 			break
 		}
 	}
+	return nil
+}
+
+// abs returns the absolute form of a path on the host's filesystem,
+// resolving it against StartDir if it is relative and StartDir is set.
+func (q Query) abs(name string) (string, error) {
+	if q.StartDir != "" && !filepath.IsAbs(name) {
+		name = filepath.Join(q.StartDir, name)
+	}
+	return filepath.Abs(name)
+}
+
+// ApplyDefaults returns a copy of a section with the default values added for
+// supported properties which depend on others, as Find does after resolving
+// the properties for a file. For example, when indent_style is "tab",
+// indent_size defaults to tab_width if set, or "tab" otherwise.
+//
+// The version is the EditorConfig spec version to follow, like Query.Version;
+// when empty, it defaults to the latest version. The original section is not
+// modified.
+func ApplyDefaults(s Section, version string) Section {
+	s = s.Clone()
+	s.applyDefaults(version)
+	return s
+}
 
-	if result.Get("indent_style") == "tab" {
-		if value := result.Get("tab_width"); value != "" {
+// applyDefaults adds the default values for supported properties which
+// depend on others, following the spec for the given version.
+func (s *Section) applyDefaults(version string) {
+	if s.Get("indent_style") == "tab" {
+		if value := s.Get("tab_width"); value != "" {
 			// When indent_style is "tab" and tab_width is set,
 			// indent_size should default to tab_width.
-			result.Add(Property{Name: "indent_size", Value: value})
+			s.Add(Property{Name: "indent_size", Value: value})
 		}
-		if q.Version != "" && q.Version < "0.9.0" { // TODO: semver comparison?
-		} else if result.Get("indent_size") == "" {
+		if version != "" && version < "0.9.0" { // TODO: semver comparison?
+		} else if s.Get("indent_size") == "" {
 			// When indent_style is "tab", indent_size defaults to
 			// "tab". Only on 0.9.0 and later.
-			result.Add(Property{Name: "indent_size", Value: "tab"})
+			s.Add(Property{Name: "indent_size", Value: "tab"})
 		}
-	} else if result.Get("tab_width") == "" {
-		if value := result.Get("indent_size"); value != "" && value != "tab" {
+	} else if s.Get("tab_width") == "" {
+		if value := s.Get("indent_size"); value != "" && value != "tab" {
 			// tab_width defaults to the value of indent_size.
-			result.Add(Property{Name: "tab_width", Value: value})
+			s.Add(Property{Name: "tab_width", Value: value})
 		}
 	}
+}
+
+// FindReader is like Find, but it resolves the properties for a file using a
+// single EditorConfig file read from r, without accessing the filesystem.
+// The name should be a path relative to the directory holding the
+// EditorConfig, as with File.Filter.
+func FindReader(r io.Reader, name string, languages []string) (Section, error) {
+	file, err := Parse(r)
+	if err != nil {
+		return Section{}, err
+	}
+	result, err := file.filter(name, languages, matcher{cache: mapCache{}}, nil)
+	if err != nil {
+		return Section{}, err
+	}
+	result.removeUnset()
+	result.applyDefaults("")
 	return result, nil
 }
 
+// evalSymlinks is like filepath.EvalSymlinks, but it allows the last element
+// of the path to not exist, as Find supports names which don't exist yet.
+func evalSymlinks(name string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		dir, err := filepath.EvalSymlinks(filepath.Dir(name))
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, filepath.Base(name)), nil
+	}
+	return resolved, err
+}
+
+// FindAll is like Find, but it resolves the properties for many files at once,
+// returning the sections in the same order as the names.
+//
+// If the query has no cache, a temporary one is used for the duration of the
+// call, so that EditorConfig files shared by the names are only parsed once.
+func (q Query) FindAll(names []string, languages []string) ([]Section, error) {
+	if q.Cache == nil && q.FileCache == nil && q.RegexpCache == nil {
+		q.FileCache = make(map[string]*File)
+		q.RegexpCache = make(map[string]*regexp.Regexp)
+	}
+	sections := make([]Section, len(names))
+	for i, name := range names {
+		section, err := q.Find(name, languages)
+		if err != nil {
+			return nil, err
+		}
+		sections[i] = section
+	}
+	return sections, nil
+}
+
+// WalkDir walks the file tree rooted at root like filepath.WalkDir, or
+// fs.WalkDir when FS is set, calling fn with the resolved properties for each
+// file which is not a directory. Like with FindAll, a temporary cache is used
+// if the query has none.
+//
+// When StartDir is set and FS is not, a relative root is resolved against
+// StartDir like the names given to Find, and the paths given to fn are
+// relative like root.
+//
+// An error from fn stops the walk and is returned, except for fs.SkipDir and
+// fs.SkipAll, which have the same meaning as in filepath.WalkDir.
+func (q Query) WalkDir(root string, languages []string, fn func(path string, section Section) error) error {
+	if q.Cache == nil && q.FileCache == nil && q.RegexpCache == nil {
+		q.FileCache = make(map[string]*File)
+		q.RegexpCache = make(map[string]*regexp.Regexp)
+	}
+	walkFn := func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		section, err := q.Find(path, languages)
+		if err != nil {
+			return err
+		}
+		return fn(path, section)
+	}
+	if q.FS != nil {
+		return fs.WalkDir(q.FS, root, walkFn)
+	}
+	if q.StartDir != "" && !filepath.IsAbs(root) {
+		base := filepath.Join(q.StartDir, root)
+		return filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+			rel, relErr := filepath.Rel(base, path)
+			if relErr != nil {
+				return relErr
+			}
+			return walkFn(filepath.Join(root, rel), d, err)
+		})
+	}
+	return filepath.WalkDir(root, walkFn)
+}
+
+// load returns the parsed EditorConfig file in a directory, or nil if there is
+// none, using and filling the cache if possible.
+func (q Query) load(dir string) (*File, error) {
+	cache := q.cache()
+	if file, ok := cache.LoadFile(dir); ok {
+		return file, nil
+	}
+	var f io.ReadCloser
+	var err error
+	if q.Open != nil {
+		f, err = q.Open(q.configPath(dir))
+	} else if q.FS != nil {
+		f, err = q.FS.Open(q.configPath(dir))
+	} else {
+		f, err = os.Open(q.configPath(dir))
+	}
+	var file *File
+	if errors.Is(err, fs.ErrNotExist) {
+		// continue below, caching the nil file
+	} else if err != nil {
+		return nil, err
+	} else {
+		file, err = ParseOptions{Strict: q.Strict}.Parse(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", q.configPath(dir), err)
+		}
+	}
+	cache.StoreFile(dir, file)
+	return file, nil
+}
+
+// Invalidate removes the cached EditorConfig file for a directory, if any, so
+// that the next Find reading from the directory loads its file again. This is
+// useful to pick up changes to an EditorConfig file, such as when watching it.
+// Cached files for other directories are left untouched.
+//
+// The directory is resolved like the names given to Find. If the query's Cache
+// does not implement FileDeleter, Invalidate does nothing.
+func (q Query) Invalidate(dir string) {
+	deleter, ok := q.cache().(FileDeleter)
+	if !ok {
+		return
+	}
+	dir, err := q.cacheDir(dir)
+	if err != nil {
+		return
+	}
+	deleter.DeleteFile(dir)
+}
+
+// Prewarm loads the EditorConfig files in the given directories into the
+// query's cache up front, including the absence of a file, so that later calls
+// to Find reading from those directories don't need to access the filesystem.
+// This helps programs which know which directories they will work on ahead of
+// time to have predictable latencies.
+//
+// Only the given directories are loaded, and not their parents. Each directory
+// is resolved like the names given to Find. Prewarm stops at the first error,
+// such as when Strict is set and a file is malformed. It is only useful when
+// the query has a Cache or FileCache.
+func (q Query) Prewarm(dirs []string) error {
+	for _, dir := range dirs {
+		dir, err := q.cacheDir(dir)
+		if err != nil {
+			return err
+		}
+		if _, err := q.load(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cacheDir returns the form of a directory used as a key by the cache.
+func (q Query) cacheDir(dir string) (string, error) {
+	if q.FS != nil {
+		return path.Clean(dir), nil
+	}
+	return q.hostPath(dir)
+}
+
+// hostPath resolves a path on the host's filesystem like Find does, making it
+// absolute and resolving symbolic links if ResolveSymlinks is set.
+func (q Query) hostPath(name string) (string, error) {
+	name, err := q.abs(name)
+	if err != nil || !q.ResolveSymlinks {
+		return name, err
+	}
+	return evalSymlinks(name)
+}
+
+// configPath returns the path to the EditorConfig file in a directory.
+func (q Query) configPath(dir string) string {
+	configName := q.ConfigName
+	if configName == "" {
+		configName = DefaultName
+	}
+	if q.FS != nil {
+		return path.Join(dir, configName)
+	}
+	return filepath.Join(dir, configName)
+}
+
+func (q Query) cache() Cache {
+	if q.Cache != nil {
+		return q.Cache
+	}
+	return mapCache{q.FileCache, q.RegexpCache}
+}
+
 // Bundle mvdan.cc/sh/v3/pattern into pattern_bundle.go,
 // since mvdan.cc/sh/v3/cmd/shfmt depends on this module
 // and we don't want to end up with circular module dependencies.
 // This should be fine, as the package is small, and the toolchain can omit what is unused.
 // Note that we can't use @version on the sh/v3 module, so we automatically pull @latest via go.mod.
 
-func toRegexp(pat string) *regexp.Regexp {
+func toRegexp(name string, fold bool) (*regexp.Regexp, error) {
+	// As per the spec, a pattern with a slash is anchored to the directory
+	// holding the EditorConfig file, so "/build" and "docs/*.md" only match
+	// "build" and "docs/x.md" there, while a pattern without slashes matches
+	// in any directory. Patterns match file names and not directories, so
+	// one ending with a slash like "docs/" matches nothing.
+	pat := name
 	if i := strings.IndexByte(pat, '/'); i == 0 {
 		pat = pat[1:]
 	} else if i < 0 {
 		pat = "**/" + pat
 	}
+	pat, err := rewriteRanges(pat)
+	if err != nil {
+		return nil, fmt.Errorf("invalid section pattern %q: %w", name, err)
+	}
+	// With patternFilenames, "**/" matches zero or more whole path
+	// segments, so "a/**/b" matches both "a/b" and "a/x/y/b".
 	rxStr, err := patternRegexp(pat, patternFilenames|patternBraces|patternEntireString)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("invalid section pattern %q: %w", name, err)
+	}
+	rxStr = excludeSlash(rxStr)
+	if fold {
+		rxStr = "(?i)" + rxStr
+	}
+	return regexp.Compile(rxStr)
+}
+
+var rxNumRange = regexp.MustCompile(`^\{([+-]?\d+)\.\.([+-]?\d+)\}`)
+
+// maxRangeValues is the maximum number of integers which a numeric range in
+// a pattern like "{1..10}" may match, as each of them becomes part of the
+// regular expression.
+const maxRangeValues = 1000
+
+// rewriteRanges rewrites the numeric ranges in a pattern so that
+// patternRegexp can translate them, which only supports ascending ranges of
+// plain integers like "{1..10}". Like the reference implementation,
+// descending ranges like "{3..1}" are swapped, and ranges whose numbers are
+// padded with leading zeros, such as "{01..10}", are expanded into a list
+// like "{01,02,...,10}". An error is returned for ranges with more than
+// maxRangeValues integers.
+func rewriteRanges(pat string) (string, error) {
+	if !strings.Contains(pat, "..") {
+		return pat, nil
 	}
-	return regexp.MustCompile(rxStr)
+	zeroPadded := func(s string) bool {
+		s = strings.TrimLeft(s, "+-")
+		return len(s) > 1 && s[0] == '0'
+	}
+	var b strings.Builder
+	for i := 0; i < len(pat); i++ {
+		c := pat[i]
+		if c == '\\' && i+1 < len(pat) {
+			b.WriteByte(c)
+			i++
+			b.WriteByte(pat[i])
+			continue
+		}
+		m := rxNumRange.FindStringSubmatch(pat[i:])
+		if c != '{' || m == nil {
+			b.WriteByte(c)
+			continue
+		}
+		start, err1 := strconv.Atoi(m[1])
+		end, err2 := strconv.Atoi(m[2])
+		if err1 != nil || err2 != nil {
+			return "", fmt.Errorf("invalid range: %q", m[0])
+		}
+		if start > end {
+			start, end = end, start
+		}
+		// The subtraction may overflow for huge bounds.
+		if n := end - start; n < 0 || n >= maxRangeValues {
+			return "", fmt.Errorf("range %q has more than %d values", m[0], maxRangeValues)
+		}
+		i += len(m[0]) - 1
+		if !zeroPadded(m[1]) && !zeroPadded(m[2]) {
+			fmt.Fprintf(&b, "{%d..%d}", start, end)
+			continue
+		}
+		width := max(len(m[1]), len(m[2]))
+		if start < end {
+			b.WriteByte('{')
+		}
+		for n := start; n <= end; n++ {
+			if n > start {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "%0*d", width, n)
+		}
+		if start < end {
+			b.WriteByte('}')
+		}
+	}
+	return b.String(), nil
 }
 
+// excludeSlash makes the negated character classes in a regular expression
+// from patternRegexp not match slashes, like "*" and "?", so that a pattern
+// such as "a[!b]c" does not match "a/c".
+func excludeSlash(rx string) string {
+	if !strings.Contains(rx, "[^") {
+		return rx
+	}
+	var b strings.Builder
+	inClass := false
+	for i := 0; i < len(rx); i++ {
+		c := rx[i]
+		b.WriteByte(c)
+		switch {
+		case c == '\\' && i+1 < len(rx):
+			i++
+			b.WriteByte(rx[i])
+		case !inClass && c == '[':
+			inClass = true
+			negated := strings.HasPrefix(rx[i+1:], "^")
+			if negated {
+				i++
+				b.WriteByte('^')
+			}
+			// A leading ']' is a literal within the class.
+			if strings.HasPrefix(rx[i+1:], "]") {
+				i++
+				b.WriteByte(']')
+			}
+			if negated && !strings.HasPrefix(rx[i+1:], "/") {
+				b.WriteByte('/')
+			}
+		case inClass && strings.HasPrefix(rx[i:], "[:"):
+			// A named class like "[:alpha:]" within the class.
+			if end := strings.Index(rx[i:], ":]"); end >= 0 {
+				b.WriteString(rx[i+1 : i+end+2])
+				i += end + 1
+			}
+		case inClass && c == ']':
+			inClass = false
+		}
+	}
+	return b.String()
+}
+
+// Parse parses an EditorConfig file from a reader.
+//
+// It is equivalent to ParseOptions{}.Parse, so lines which cannot be
+// understood are ignored.
+//
+// As with the reference implementation, when a property appears more than
+// once within a section, the last value is kept.
 func Parse(r io.Reader) (*File, error) {
+	return ParseOptions{}.Parse(r)
+}
+
+// The maximum lengths in bytes of section names, property keys, and property
+// values which Parse supports. The spec tests require supporting at least these
+// lengths; larger ones rarely make sense, and they could mean holding onto lots
+// of memory. Lines exceeding them are ignored with a warning.
+const (
+	MaxSectionNameLength   = 4096
+	MaxPropertyKeyLength   = 1024
+	MaxPropertyValueLength = 4096
+)
+
+// ParseOptions allows fine-grained control of how EditorConfig files are
+// parsed.
+type ParseOptions struct {
+	// Strict makes Parse return a *ParseError for lines which would
+	// otherwise be ignored, such as a section header without a closing
+	// bracket, a line without a key-value separator, a property other
+	// than root before the first section, or root within a section.
+	Strict bool
+
+	// Comments keeps whole-line comments in the Comment fields of the
+	// root property, section, or property which follows them, so that
+	// String can reproduce them. Blank lines within a block of comments are
+	// kept as well. The file's Comment is the block of comments before root,
+	// or else the comments up to the last blank line before any section.
+	// Comments at the end of the file are dropped, unless the file has no
+	// sections nor root. The comments of a property repeated within a
+	// section are joined.
+	Comments bool
+
+	// KeepOrder records the order in which the properties of each section
+	// appear, so that printing the file keeps that order instead of
+	// sorting the properties by name. This helps tools which modify a file
+	// to produce minimal diffs. Properties added later are printed after
+	// the parsed ones, sorted by name.
+	KeepOrder bool
+
+	// EqualsOnly only accepts "=" as the separator between keys and
+	// values, as in the spec, instead of "=" or ":". This way, lines are
+	// never split at colons, such as those in Windows paths like "C:\foo".
+	// Lines without an "=" are then ignored.
+	EqualsOnly bool
+
+	// MaxSectionNameLength, MaxPropertyKeyLength, and
+	// MaxPropertyValueLength override the limits of the same names in this
+	// package when positive, such as to support longer values for custom
+	// properties.
+	MaxSectionNameLength   int
+	MaxPropertyKeyLength   int
+	MaxPropertyValueLength int
+}
+
+// ParseError is an error found when parsing an EditorConfig file.
+type ParseError struct {
+	// Line is the 1-based line number where the error was found.
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string { return fmt.Sprintf("line %d: %s", e.Line, e.Msg) }
+
+// Parse parses an EditorConfig file from a reader as configured by the options.
+func (o ParseOptions) Parse(r io.Reader) (*File, error) {
+	return o.parse(r, nil)
+}
+
+// Warning describes a line which was ignored when parsing an EditorConfig
+// file, such as a property whose value is too long.
+type Warning struct {
+	// Line is the 1-based line number of the ignored line.
+	Line int
+	Msg  string
+}
+
+func (w Warning) String() string { return fmt.Sprintf("line %d: %s", w.Line, w.Msg) }
+
+// ParseWithWarnings is like Parse, but it also returns warnings for the lines
+// which were ignored. It is equivalent to ParseOptions{}.ParseWithWarnings.
+func ParseWithWarnings(r io.Reader) (*File, []Warning, error) {
+	return ParseOptions{}.ParseWithWarnings(r)
+}
+
+// ParseWithWarnings is like Parse, but it also returns warnings for the lines
+// which were ignored.
+func (o ParseOptions) ParseWithWarnings(r io.Reader) (*File, []Warning, error) {
+	var warnings []Warning
+	f, err := o.parse(r, func(line int, msg string) {
+		warnings = append(warnings, Warning{Line: line, Msg: msg})
+	})
+	return f, warnings, err
+}
+
+// parse implements Parse, calling warn for each ignored line if non-nil.
+func (o ParseOptions) parse(r io.Reader, warn func(line int, msg string)) (*File, error) {
+	if warn == nil {
+		warn = func(int, string) {}
+	}
+	maxName := cmpOr(o.MaxSectionNameLength, MaxSectionNameLength)
+	maxKey := cmpOr(o.MaxPropertyKeyLength, MaxPropertyKeyLength)
+	maxValue := cmpOr(o.MaxPropertyValueLength, MaxPropertyValueLength)
 	f := &File{}
-	scanner := bufio.NewScanner(r)
+	// Make room for the longest lines within the limits, plus some spacing.
+	lines := lineReader{
+		r:   bufio.NewReader(r),
+		max: bufio.MaxScanTokenSize + max(maxName, maxKey+maxValue),
+	}
 	var section *Section
-	for scanner.Scan() {
-		line := scanner.Text()
-		if i := strings.Index(line, " #"); i >= 0 {
-			line = line[:i]
-		} else if i := strings.Index(line, " ;"); i >= 0 {
-			line = line[:i]
+	var comment []string
+	takeComment := func() string {
+		for len(comment) > 0 && comment[len(comment)-1] == "" {
+			comment = comment[:len(comment)-1]
+		}
+		text := strings.Join(comment, "\n")
+		comment = comment[:0]
+		return text
+	}
+	// The file's comment is the one before root, or else the comments up
+	// to the last blank line before the first section.
+	takeFileComment := func() {
+		if f.Root || f.Comment != "" || len(f.Sections) > 0 {
+			return
+		}
+		for i := len(comment) - 1; i >= 0; i-- {
+			if comment[i] == "" {
+				f.Comment = strings.TrimRight(strings.Join(comment[:i], "\n"), "\n")
+				comment = slices.Delete(comment, 0, i+1)
+				break
+			}
+		}
+	}
+	for lineNum := 1; ; lineNum++ {
+		line, long, err := lines.next()
+		if err == io.EOF {
+			// Without any sections, a file may consist of its comment.
+			if !f.Root && len(f.Sections) == 0 && f.Comment == "" {
+				f.Comment = takeComment()
+			}
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if long {
+			// Don't hold onto arbitrarily long lines; we only keep
+			// their start to ignore the section they might begin.
+			warn(lineNum, fmt.Sprintf("line is longer than %d bytes; ignoring it", lines.max))
+			if strings.HasPrefix(strings.TrimSpace(line), "[") {
+				section = &Section{} // ignore
+			}
+			continue
+		}
+		if lineNum == 1 {
+			// Some editors save files with a UTF-8 byte order mark.
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if trimmed := strings.TrimSpace(line); o.Comments && trimmed != "" && (trimmed[0] == '#' || trimmed[0] == ';') {
+			comment = append(comment, trimmed)
+			continue
 		}
 		line = strings.TrimSpace(line)
+		if line == "" {
+			if len(comment) > 0 {
+				comment = append(comment, "")
+			}
+			continue
+		}
+		if line[0] == '#' || line[0] == ';' {
+			continue
+		}
 
-		if len(line) > 2 && line[0] == '[' && line[len(line)-1] == ']' {
-			name := line[1 : len(line)-1]
-			if len(name) > 4096 {
+		if name, lang := sectionHeader(line); name != "" {
+			takeFileComment()
+			if len(name) > maxName {
+				warn(lineNum, fmt.Sprintf("section name is longer than %d bytes; ignoring the section", maxName))
+				section = &Section{} // ignore
+				continue
+			}
+			if lang != "" && (name[0] == '#' || name[0] == ';') {
+				// Likely a comment after "[[lang]]", which is not allowed.
+				msg := "language section pattern starts like a comment"
+				if o.Strict {
+					return nil, &ParseError{Line: lineNum, Msg: msg}
+				}
+				warn(lineNum, msg+"; ignoring the section")
 				section = &Section{} // ignore
 				continue
 			}
-			f.Sections = append(f.Sections, Section{Name: name})
+			f.Sections = append(f.Sections, Section{Name: name, Language: lang, Comment: takeComment()})
 			section = &f.Sections[len(f.Sections)-1]
 			continue
 		}
-		i := strings.IndexAny(line, "=:")
+		if o.Strict && line[0] == '[' {
+			return nil, &ParseError{Line: lineNum, Msg: "section header is missing its closing bracket"}
+		}
+		// The key ends at the first separator, be it "=" or ":",
+		// and the value may contain more of either.
+		seps := "=:"
+		if o.EqualsOnly {
+			seps = "="
+		}
+		i := strings.IndexAny(line, seps)
 		if i < 0 {
+			if o.Strict {
+				msg := "expected a key-value pair separated by = or :"
+				if o.EqualsOnly {
+					msg = "expected a key-value pair separated by ="
+				}
+				return nil, &ParseError{Line: lineNum, Msg: msg}
+			}
 			continue
 		}
 		key := strings.ToLower(strings.TrimSpace(line[:i]))
 		value := strings.TrimSpace(line[i+1:])
+		if o.Strict && key == "" {
+			return nil, &ParseError{Line: lineNum, Msg: "property is missing its key"}
+		}
 		switch key {
 		case "root", "indent_style", "indent_size", "tab_width", "end_of_line",
 			"charset", "trim_trailing_whitespace", "insert_final_newline":
 			value = strings.ToLower(value)
 		}
-		// The spec tests require supporting at least these lengths.
-		// Larger lengths rarely make sense,
-		// and they could mean holding onto lots of memory,
-		// so use them as limits.
-		if len(key) > 1024 || len(value) > 4096 {
+		if len(key) > maxKey {
+			warn(lineNum, fmt.Sprintf("property key is longer than %d bytes", maxKey))
+			continue
+		}
+		if len(value) > maxValue {
+			warn(lineNum, fmt.Sprintf("value of property %q is longer than %d bytes", key, maxValue))
 			continue
 		}
+		if section == nil {
+			f.preamble = append(f.preamble, Property{Name: key, Value: value})
+		}
+		if section != nil && key == "root" {
+			// root only has an effect before the first section,
+			// so this is most likely a mistake.
+			msg := `property "root" appears inside a section; it only has an effect before any section`
+			if o.Strict {
+				return nil, &ParseError{Line: lineNum, Msg: msg}
+			}
+			warn(lineNum, msg)
+		}
 		if section != nil {
-			section.Add(Property{Name: key, Value: value})
+			if o.KeepOrder && !section.Has(key) {
+				section.order = append(section.order, key)
+			}
+			// The last value within a section wins, and the
+			// comments of every occurrence are kept in order.
+			comment := takeComment()
+			if prop := section.Lookup(key); prop != nil {
+				prop.Value = value
+				if prop.Comment != "" && comment != "" {
+					prop.Comment += "\n"
+				}
+				prop.Comment += comment
+			} else {
+				section.Add(Property{Name: key, Value: value, Comment: comment})
+			}
 		} else if key == "root" {
 			f.Root = value == "true"
+			f.Comment = takeComment()
+		} else if msg := fmt.Sprintf("property %q appears before any section", key); o.Strict {
+			return nil, &ParseError{Line: lineNum, Msg: msg}
+		} else {
+			warn(lineNum, msg)
 		}
 	}
 	return f, nil
 }
+
+// sectionHeader returns the name of the section which a line starts, or an
+// empty string if the line is not a section header. Besides the usual form
+// like "[*.go]", a language section with a pattern such as "[[go]] *_test.go"
+// has the name "*_test.go" and the language "go".
+func sectionHeader(line string) (name, lang string) {
+	if strings.HasPrefix(line, "[[") {
+		if end := strings.Index(line, "]]"); end > 2 {
+			lang, pattern := line[2:end], strings.TrimSpace(line[end+2:])
+			if pattern != "" && !strings.ContainsAny(lang, "[]") {
+				return pattern, lang
+			}
+		}
+	}
+	if len(line) > 2 && line[0] == '[' && line[len(line)-1] == ']' {
+		return line[1 : len(line)-1], ""
+	}
+	return "", ""
+}
+
+func isSectionHeader(line string) bool {
+	name, _ := sectionHeader(line)
+	return name != ""
+}
+
+// cmpOr returns n if it is positive, and def otherwise.
+func cmpOr(n, def int) int {
+	if n > 0 {
+		return n
+	}
+	return def
+}
+
+// lineReader reads lines ending with "\n", "\r\n", or "\r", without their
+// terminators.
+type lineReader struct {
+	r   *bufio.Reader
+	max int // the maximum length of a line to keep
+	buf []byte
+}
+
+// next returns the next line, or io.EOF if there are no more lines. If the
+// line is longer than max, only its start is returned, and long is true.
+func (lr *lineReader) next() (line string, long bool, err error) {
+	lr.buf = lr.buf[:0]
+	read := false
+	for {
+		c, err := lr.r.ReadByte()
+		if err == io.EOF && read {
+			return string(lr.buf), long, nil
+		} else if err != nil {
+			return "", false, err
+		}
+		switch c {
+		case '\n':
+			return string(lr.buf), long, nil
+		case '\r':
+			if c, err := lr.r.ReadByte(); err == nil && c != '\n' {
+				lr.r.UnreadByte()
+			}
+			return string(lr.buf), long, nil
+		}
+		read = true
+		if len(lr.buf) < lr.max {
+			lr.buf = append(lr.buf, c)
+		} else {
+			long = true
+		}
+	}
+}
+
+// ParseFile opens and parses the EditorConfig file at the given path.
+func ParseFile(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	file, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return file, nil
+}
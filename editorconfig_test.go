@@ -4,12 +4,21 @@
 package editorconfig
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"sync"
 	"testing"
+	"testing/fstest"
 )
 
 func TestMain(m *testing.M) {
@@ -61,7 +70,18 @@ func TestViaCmake(t *testing.T) {
 }
 
 func TestConcurrentQuery(t *testing.T) {
-	q := Query{}
+	t.Run("NoCache", func(t *testing.T) {
+		testConcurrentQuery(t, Query{})
+	})
+	t.Run("SyncCache", func(t *testing.T) {
+		testConcurrentQuery(t, Query{Cache: new(SyncCache)})
+	})
+	t.Run("LRUCache", func(t *testing.T) {
+		testConcurrentQuery(t, Query{Cache: &LRUCache{MaxEntries: 2}})
+	})
+}
+
+func testConcurrentQuery(t *testing.T, q Query) {
 	n := 100
 	name := "_sample/subdir/code.go"
 
@@ -88,3 +108,2086 @@ func TestConcurrentQuery(t *testing.T) {
 		_ = section.String()
 	})
 }
+
+func TestAddSorted(t *testing.T) {
+	var s Section
+	s.Add(
+		Property{Name: "indent_size", Value: "4"},
+		Property{Name: "charset", Value: "utf-8"},
+		Property{Name: "tab_width", Value: "8"},
+	)
+	s.Add(
+		Property{Name: "indent_size", Value: "2"},
+		Property{Name: "end_of_line", Value: "lf"},
+	)
+	want := "charset=utf-8\nend_of_line=lf\nindent_size=4\ntab_width=8\n"
+	if got := s.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+	for _, prop := range s.Properties {
+		if got := s.Get(prop.Name); got != prop.Value {
+			t.Errorf("Get(%q) = %q, want %q", prop.Name, got, prop.Value)
+		}
+	}
+	if got := s.Lookup("insert_final_newline"); got != nil {
+		t.Errorf("Lookup of a missing property returned %v", got)
+	}
+}
+
+func TestSet(t *testing.T) {
+	var s Section
+	s.Add(Property{Name: "indent_style", Value: "tab"})
+	s.Set(Property{Name: "indent_style", Value: "space"})
+	s.Set(Property{Name: "indent_size", Value: "2"})
+	want := "indent_size=2\nindent_style=space\n"
+	if got := s.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	var s Section
+	s.Add(
+		Property{Name: "charset", Value: "utf-8"},
+		Property{Name: "indent_size", Value: "2"},
+		Property{Name: "indent_style", Value: "space"},
+	)
+	if !s.Remove("indent_size") {
+		t.Fatal("Remove of an existing property returned false")
+	}
+	if s.Remove("indent_size") {
+		t.Fatal("Remove of a missing property returned true")
+	}
+	want := "charset=utf-8\nindent_style=space\n"
+	if got := s.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestHasGetOr(t *testing.T) {
+	var s Section
+	s.Add(Property{Name: "empty", Value: ""})
+	if !s.Has("empty") {
+		t.Error("Has of a property with an empty value returned false")
+	}
+	if s.Has("missing") {
+		t.Error("Has of a missing property returned true")
+	}
+	if got := s.GetOr("empty", "def"); got != "" {
+		t.Errorf("GetOr of a property with an empty value returned %q", got)
+	}
+	if got := s.GetOr("missing", "def"); got != "def" {
+		t.Errorf("GetOr of a missing property returned %q", got)
+	}
+}
+
+func TestGetAllocs(t *testing.T) {
+	s := section("charset", "utf-8", "indent_size", "4", "indent_style", "space",
+		"insert_final_newline", "true", "tab_width", "8")
+	names := []string{"indent_style", "indent_size", "tab_width", "end_of_line", "charset"}
+	allocs := testing.AllocsPerRun(100, func() {
+		for _, name := range names {
+			s.Get(name)
+			s.GetOr(name, "default")
+			s.Has(name)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("Get allocated %v times per run, want 0", allocs)
+	}
+}
+
+func TestRange(t *testing.T) {
+	s := section("tab_width", "8", "charset", "utf-8", "indent_size", "4")
+	var names []string
+	s.Range(func(prop Property) bool {
+		names = append(names, prop.Name)
+		return true
+	})
+	if want := "[charset indent_size tab_width]"; fmt.Sprint(names) != want {
+		t.Fatalf("want %s, got %v", want, names)
+	}
+
+	names = nil
+	s.Range(func(prop Property) bool {
+		names = append(names, prop.Name)
+		return prop.Name != "indent_size"
+	})
+	if want := "[charset indent_size]"; fmt.Sprint(names) != want {
+		t.Fatalf("want %s after stopping early, got %v", want, names)
+	}
+}
+
+func TestEndOfLine(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"", ""},
+		{"lf", "\n"},
+		{"crlf", "\r\n"},
+		{"cr", "\r"},
+		{"garbage", ""},
+	}
+	for _, tc := range tests {
+		var s Section
+		if tc.value != "" {
+			s.Add(Property{Name: "end_of_line", Value: tc.value})
+		}
+		if got := s.EndOfLine(); got != tc.want {
+			t.Errorf("EndOfLine with %q: want %q, got %q", tc.value, tc.want, got)
+		}
+	}
+}
+
+func TestIndentSizeTab(t *testing.T) {
+	tests := []struct {
+		value string
+		size  int
+		tab   bool
+	}{
+		{"", 0, false},
+		{"4", 4, false},
+		{"0", 0, false},
+		{"tab", 0, true},
+		{"unset", 0, false},
+	}
+	for _, tc := range tests {
+		var s Section
+		if tc.value != "" {
+			s.Add(Property{Name: "indent_size", Value: tc.value})
+		}
+		if got := s.IndentSize(); got != tc.size {
+			t.Errorf("IndentSize with %q: want %d, got %d", tc.value, tc.size, got)
+		}
+		if got := s.IndentSizeTab(); got != tc.tab {
+			t.Errorf("IndentSizeTab with %q: want %t, got %t", tc.value, tc.tab, got)
+		}
+	}
+}
+
+func TestTabWidth(t *testing.T) {
+	tests := []struct {
+		section Section
+		want    int
+	}{
+		{section(), 0},
+		{section("indent_size", "4"), 4},
+		{section("indent_size", "tab"), 0},
+		{section("indent_size", "tab", "tab_width", "8"), 8},
+		{section("tab_width", "8"), 8},
+		{section("indent_size", "2", "tab_width", "8"), 8},
+	}
+	for _, tc := range tests {
+		if got := tc.section.TabWidth(); got != tc.want {
+			t.Errorf("TabWidth with %v: want %d, got %d", tc.section.Properties, tc.want, got)
+		}
+	}
+}
+
+func TestCharset(t *testing.T) {
+	tests := []struct {
+		value string
+		valid bool
+	}{
+		{"", false},
+		{"utf-8", true},
+		{"utf-8-bom", true},
+		{"latin1", true},
+		{"utf-16be", true},
+		{"utf-16le", true},
+		{"utf8", false},
+	}
+	for _, tc := range tests {
+		var s Section
+		if tc.value != "" {
+			s.Add(Property{Name: "charset", Value: tc.value})
+		}
+		if got := s.Charset(); got != tc.value {
+			t.Errorf("Charset: want %q, got %q", tc.value, got)
+		}
+		if got := s.CharsetValid(); got != tc.valid {
+			t.Errorf("CharsetValid with %q: want %t, got %t", tc.value, tc.valid, got)
+		}
+	}
+}
+
+func TestMaxLineLength(t *testing.T) {
+	tests := []struct {
+		value   string
+		n       int
+		off, ok bool
+	}{
+		{"", 0, false, false},
+		{"off", 0, true, true},
+		{"100", 100, false, true},
+		{"banana", 0, false, false},
+	}
+	for _, tc := range tests {
+		var s Section
+		if tc.value != "" {
+			s.Add(Property{Name: "max_line_length", Value: tc.value})
+		}
+		n, off, ok := s.MaxLineLength()
+		if n != tc.n || off != tc.off || ok != tc.ok {
+			t.Errorf("MaxLineLength with %q: want (%d, %t, %t), got (%d, %t, %t)",
+				tc.value, tc.n, tc.off, tc.ok, n, off, ok)
+		}
+	}
+}
+
+func TestIndentStyleValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  IndentStyle
+	}{
+		{"", IndentUnset},
+		{"tab", IndentTab},
+		{"space", IndentSpace},
+		{"tabs", IndentUnset},
+	}
+	for _, tc := range tests {
+		var s Section
+		if tc.value != "" {
+			s.Add(Property{Name: "indent_style", Value: tc.value})
+		}
+		if got := s.IndentStyleValue(); got != tc.want {
+			t.Errorf("IndentStyleValue with %q: want %q, got %q", tc.value, tc.want, got)
+		}
+	}
+}
+
+func TestIndentText(t *testing.T) {
+	tests := []struct {
+		section Section
+		want    string
+	}{
+		{section(), ""},
+		{section("indent_size", "4"), ""},
+		{section("indent_style", "tab"), "\t"},
+		{section("indent_style", "tab", "indent_size", "4", "tab_width", "4"), "\t"},
+		{section("indent_style", "space", "indent_size", "2"), "  "},
+		{section("indent_style", "space"), ""},
+		{section("indent_style", "space", "indent_size", "-3"), ""},
+	}
+	for _, tc := range tests {
+		if got := tc.section.IndentText(); got != tc.want {
+			t.Errorf("IndentText of %q: want %q, got %q", tc.section, tc.want, got)
+		}
+	}
+}
+
+func TestUnset(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, ".editorconfig"), `
+root = true
+
+[*]
+indent_size = 4
+charset = utf-8
+`)
+	writeFile(t, filepath.Join(dir, "sub", ".editorconfig"), `
+[*]
+indent_size = unset
+
+[*.txt]
+charset = unset
+`)
+
+	file, err := Parse(strings.NewReader("[*]\nindent_size = 4\n[*.go]\nindent_size = unset\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := file.Filter("main.go", nil, nil).String(); got != "" {
+		t.Errorf("Filter kept unset properties:\n%s", got)
+	}
+
+	section, err := Find(filepath.Join(dir, "sub", "foo.txt"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := section.String(); got != "" {
+		t.Errorf("Find kept unset properties:\n%s", got)
+	}
+}
+
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0o666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	tests := []struct {
+		in      Section
+		version string
+		want    string
+	}{
+		{section(), "", ""},
+		{section("indent_style", "tab"), "", "indent_size=tab\nindent_style=tab\n"},
+		{section("indent_style", "tab"), "0.8.0", "indent_style=tab\n"},
+		{section("indent_style", "tab", "tab_width", "4"), "", "indent_size=4\nindent_style=tab\ntab_width=4\n"},
+		{section("indent_size", "2"), "", "indent_size=2\ntab_width=2\n"},
+		{section("indent_size", "2", "tab_width", "8"), "", "indent_size=2\ntab_width=8\n"},
+		{section("indent_size", "tab"), "", "indent_size=tab\n"},
+	}
+	for _, tc := range tests {
+		before := tc.in.String()
+		if got := ApplyDefaults(tc.in, tc.version).String(); got != tc.want {
+			t.Errorf("ApplyDefaults(%q, %q): want %q, got %q", before, tc.version, tc.want, got)
+		}
+		if after := tc.in.String(); after != before {
+			t.Errorf("ApplyDefaults modified its input from %q to %q", before, after)
+		}
+	}
+}
+
+func TestQueryDefaults(t *testing.T) {
+	fsys := fstest.MapFS{
+		".editorconfig": {Data: []byte("[*]\nindent_style = tab\n[*.md]\ncharset = latin1\n[*.txt]\ncharset = unset\n")},
+	}
+	q := Query{FS: fsys, Defaults: []Property{
+		{Name: "charset", Value: "utf-8"},
+		{Name: "tab_width", Value: "4"},
+	}}
+	for _, tc := range []struct {
+		name, want string
+	}{
+		{"main.go", "charset=utf-8\nindent_size=4\nindent_style=tab\ntab_width=4\n"},
+		{"README.md", "charset=latin1\nindent_size=4\nindent_style=tab\ntab_width=4\n"},
+		{"notes.txt", "indent_size=4\nindent_style=tab\ntab_width=4\n"},
+	} {
+		section, sources, err := q.FindSources(tc.name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := section.String(); got != tc.want {
+			t.Errorf("%s: want %q, got %q", tc.name, tc.want, got)
+		}
+		for _, src := range sources {
+			if src.Property == "tab_width" {
+				t.Errorf("%s: default tab_width has a source: %v", tc.name, src)
+			}
+		}
+	}
+}
+
+func TestQueryStrict(t *testing.T) {
+	fsys := fstest.MapFS{
+		".editorconfig":     {Data: []byte("[*]\ncharset = utf-8\n")},
+		"sub/.editorconfig": {Data: []byte("[*]\nindent_style tab\nindent_size = 2\n")},
+	}
+	section, err := Query{FS: fsys}.Find("sub/main.go", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := section.String(), "charset=utf-8\nindent_size=2\ntab_width=2\n"; got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+
+	_, err = Query{FS: fsys, Strict: true}.Find("sub/main.go", nil)
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Line != 2 {
+		t.Fatalf("want a ParseError on line 2, got %v", err)
+	}
+	if want := "sub/.editorconfig: line 2: "; !strings.HasPrefix(err.Error(), want) {
+		t.Fatalf("want an error starting with %q, got %q", want, err)
+	}
+}
+
+func TestQueryNoDefaults(t *testing.T) {
+	fsys := fstest.MapFS{
+		".editorconfig": {Data: []byte("[*]\nindent_style = tab\ntab_width = 4\n")},
+	}
+	eol := []Property{{Name: "end_of_line", Value: "lf"}}
+	for _, tc := range []struct {
+		noDefaults bool
+		defaults   []Property
+		want       string
+	}{
+		{false, nil, "indent_size=4\nindent_style=tab\ntab_width=4\n"},
+		{true, nil, "indent_style=tab\ntab_width=4\n"},
+		{false, eol, "end_of_line=lf\nindent_size=4\nindent_style=tab\ntab_width=4\n"},
+		{true, eol, "end_of_line=lf\nindent_style=tab\ntab_width=4\n"},
+	} {
+		q := Query{FS: fsys, NoDefaults: tc.noDefaults, Defaults: tc.defaults}
+		section, err := q.Find("main.go", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := section.String(); got != tc.want {
+			t.Errorf("NoDefaults=%t with %v: want %q, got %q", tc.noDefaults, tc.defaults, tc.want, got)
+		}
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".editorconfig")
+	writeFile(t, path, "root = true\n\n[*]\nindent_style = tab\n")
+	file, err := ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "root=true\n\n[*]\nindent_style=tab\n"
+	if got := file.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	if _, err := ParseFile(missing); !os.IsNotExist(err) || !strings.Contains(err.Error(), missing) {
+		t.Fatalf("want a not-exist error mentioning the path, got %v", err)
+	}
+}
+
+func TestQueryFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		".editorconfig":     {Data: []byte("[*]\nindent_style = tab\n\n[sub/*.go]\nindent_size = 8\n")},
+		"sub/.editorconfig": {Data: []byte("[*.go]\ncharset = utf-8\n")},
+		"sub/main.go":       {},
+	}
+	q := Query{FS: fsys}
+	section, err := q.Find("sub/main.go", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "charset=utf-8\nindent_size=8\nindent_style=tab\n"
+	if got := section.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+
+	if _, err := q.Find("/sub/main.go", nil); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("want an invalid path error, got %v", err)
+	}
+}
+
+func TestFindContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := (Query{}).FindContext(ctx, "_sample/subdir/code.go", nil); err != context.Canceled {
+		t.Fatalf("want %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestMatchError(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+		wantErr       bool
+	}{
+		{"*.go", "main.go", true, false},
+		{"*.go", "sub/main.go", true, false},
+		{"sub/*.go", filepath.Join("sub", "main.go"), true, false},
+		{"sub/*.go", filepath.Join("other", "sub", "main.go"), false, false},
+		{"*.go", "main.js", false, false},
+		{"[[go]]", "main.go", false, false},
+		{"a[b", "ab", false, true},
+		{"{3..1}", "2", true, false},
+		{"{00..9999999}", "05", false, true},
+		{"{00..999999999}", "05", false, true},
+		{"{0..9999999}", "5", false, true},
+		{"{1..99999999999999999999}", "5", false, true},
+	}
+	for _, tc := range tests {
+		got, err := Section{Name: tc.pattern}.MatchError(tc.name)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("MatchError(%q) on %q: unexpected error: %v", tc.name, tc.pattern, err)
+		}
+		if got != tc.want {
+			t.Errorf("MatchError(%q) on %q: want %t, got %t", tc.name, tc.pattern, tc.want, got)
+		}
+	}
+
+	fsys := fstest.MapFS{
+		".editorconfig": {Data: []byte("[a[b]\nindent_size = 2\n\n[*]\nindent_style = tab\n")},
+	}
+	file, err := Parse(bytes.NewReader(fsys[".editorconfig"].Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "indent_style=tab\n"
+	if got := file.Filter("main.go", nil, nil).String(); got != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, got)
+	}
+	if _, err := (Query{FS: fsys}).Find("main.go", nil); err == nil {
+		t.Errorf("Find with an invalid pattern did not error")
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	tests := []struct {
+		src  string
+		line int
+	}{
+		{"root = true\n\n[*]\nindent_style = tab\n# comment\n; comment\n", 0},
+		{"[*]\nindent_style tab\n", 2},
+		{"root = true\n[*.go\nindent_style = tab\n", 2},
+		{"indent_style = tab\n[*]\n", 1},
+		{"[*]\n\n = tab\n", 3},
+		{"[*]\nindent_style = tab\nroot = true\n", 3},
+	}
+	for _, tc := range tests {
+		_, err := ParseOptions{Strict: true}.Parse(strings.NewReader(tc.src))
+		if tc.line == 0 {
+			if err != nil {
+				t.Errorf("unexpected error parsing %q: %v", tc.src, err)
+			}
+			continue
+		}
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("wanted a ParseError parsing %q, got %v", tc.src, err)
+			continue
+		}
+		if perr.Line != tc.line {
+			t.Errorf("wanted an error on line %d parsing %q, got %v", tc.line, tc.src, err)
+		}
+		if _, err := Parse(strings.NewReader(tc.src)); err != nil {
+			t.Errorf("unexpected error parsing %q leniently: %v", tc.src, err)
+		}
+	}
+}
+
+func TestFileWriteTo(t *testing.T) {
+	file, err := Parse(strings.NewReader("root = true\n[*]\nindent_style = tab\n[*.md]\ntrim_trailing_whitespace = false\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	n, err := file.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), file.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+	if n != int64(b.Len()) {
+		t.Fatalf("WriteTo returned %d, but wrote %d bytes", n, b.Len())
+	}
+}
+
+func TestParseKeepOrder(t *testing.T) {
+	in := "[*]\nindent_style = tab\ncharset = utf-8\nindent_style = space\nend_of_line = lf\n"
+	file, err := ParseOptions{KeepOrder: true}.Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A repeated property keeps its first position, but its last value.
+	want := "[*]\nindent_style=space\ncharset=utf-8\nend_of_line=lf\n"
+	if got := file.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+
+	// Edits keep the order, and new properties go at the end.
+	section := &file.Sections[0]
+	section.Set(Property{Name: "charset", Value: "latin1"})
+	section.Remove("end_of_line")
+	section.Add(Property{Name: "tab_width", Value: "8"}, Property{Name: "indent_size", Value: "8"})
+	want = "[*]\nindent_style=space\ncharset=latin1\nindent_size=8\ntab_width=8\n"
+	if got := file.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+	if got := file.Clone().String(); got != want {
+		t.Fatalf("Clone did not keep the order:\n%s", got)
+	}
+
+	// The properties themselves are still sorted.
+	if got := section.Properties[0].Name; got != "charset" {
+		t.Fatalf("want the first property to be charset, got %q", got)
+	}
+}
+
+func TestGoString(t *testing.T) {
+	file, err := Parse(strings.NewReader("root = true\n[*.go]\nindent_style = tab\n[*.md]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	want := `&editorconfig.File{Root:true, Sections:[]editorconfig.Section{` +
+		`editorconfig.Section{Name:"*.go", Properties:[]editorconfig.Property{editorconfig.Property{Name:"indent_style", Value:"tab", Comment:""}}}, ` +
+		`editorconfig.Section{Name:"*.md"}}}`
+	if got := fmt.Sprintf("%#v", file); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+	if got, want := fmt.Sprintf("%#v", (*File)(nil)), "(*editorconfig.File)(nil)"; got != want {
+		t.Fatalf("want %s, got %s", want, got)
+	}
+}
+
+func TestPrintCanonicalOrder(t *testing.T) {
+	in := "[*]\ninsert_final_newline = true\ncustom = x\ncharset = utf-8\nindent_size = 2\nmax_line_length = 80\nindent_style = space\nend_of_line = lf\n"
+	want := "[*]\nindent_style=space\nindent_size=2\nend_of_line=lf\ncharset=utf-8\ninsert_final_newline=true\ncustom=x\nmax_line_length=80\n"
+	for _, keepOrder := range []bool{false, true} {
+		file, err := ParseOptions{KeepOrder: keepOrder}.Parse(strings.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b strings.Builder
+		if _, err := (PrintOptions{CanonicalOrder: true}).Print(&b, file); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.String(); got != want {
+			t.Fatalf("KeepOrder=%t: want:\n%s\ngot:\n%s", keepOrder, want, got)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	inputs := []string{
+		"root = TRUE\n[*]\nIndent_Style = TAB\n",
+		"root = yes\n[*]\nkey =\n",
+		"#\nroot =\n",
+		"# only a comment\n",
+		"# file\n\n# more\nroot=false\n[*]\n",
+		"root=false\n# a\n\n# b\n[*]\n",
+		"# header\n\n# more\n\n# section\n[*]\n",
+		"# c\n\n# d\nroot=true\n\n# e\n\n#f\n[*]\n# g\n\n; h\na=b\n# trailing\n",
+		"[*]\nkey = a = b\nk2 : c : d\nvalue = #not a comment\n",
+		"[*]\nindent_size = 2\nindent_size = 4\n",
+		"[*]\nz=1\na=2\n[*.go]\nb=3\n[*]\na=4\n",
+		"[[go]] *_test.go\na=b\n[[go]]\nc=d\n",
+		"[a]b]\nc=d\n[x=y\n",
+	}
+	for _, in := range inputs {
+		for _, opts := range []ParseOptions{{}, {Comments: true, KeepOrder: true}} {
+			f1, err := opts.Parse(strings.NewReader(in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			f1 = f1.Normalize()
+			out1 := f1.String()
+			f2, err := opts.Parse(strings.NewReader(out1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			f2 = f2.Normalize()
+			if out2 := f2.String(); out2 != out1 {
+				t.Errorf("%+v: String of %q is not idempotent:\n%s\nthen:\n%s", opts, in, out1, out2)
+			}
+			if got, want := fmt.Sprintf("%#v", f2), fmt.Sprintf("%#v", f1); got != want {
+				t.Errorf("%+v: parsing the output of %q gives a different file:\n%s\nthen:\n%s", opts, in, want, got)
+			}
+		}
+	}
+}
+
+func TestPrintInvalidProperties(t *testing.T) {
+	tests := []struct {
+		prop    Property
+		wantErr string
+	}{
+		{Property{Name: "path", Value: `C:\foo #bar`}, ""},
+		{Property{Name: "[weird", Value: "name"}, ""},
+		{Property{Name: "multi", Value: "line\nvalue"}, "value contains a newline"},
+		{Property{Name: "spaced", Value: "value "}, "value has leading or trailing whitespace"},
+		{Property{Name: "Upper", Value: "x"}, "name is not lowercase"},
+		{Property{Name: "a:b", Value: "x"}, "name contains a separator or newline"},
+		{Property{Name: "#a", Value: "x"}, "name starts like a comment"},
+		{Property{Name: "[a", Value: "b]"}, "it looks like a section header"},
+	}
+	for _, tc := range tests {
+		file := &File{Sections: []Section{section("a", "1", "z", "2")}}
+		file.Sections[0].Name = "*"
+		file.Sections[0].Add(tc.prop)
+		var b strings.Builder
+		_, err := file.WriteTo(&b)
+		if tc.wantErr == "" {
+			if err != nil {
+				t.Errorf("%v: unexpected error: %v", tc.prop, err)
+			}
+			if want := fmt.Sprintf("%v\n", tc.prop); !strings.Contains(b.String(), want) {
+				t.Errorf("%v: want output containing %q, got %q", tc.prop, want, b.String())
+			}
+			continue
+		}
+		if err == nil || !strings.HasSuffix(err.Error(), tc.wantErr) {
+			t.Errorf("%v: want error ending with %q, got %v", tc.prop, tc.wantErr, err)
+		}
+		// The other properties are still printed.
+		want := "[*]\na=1\nz=2\n"
+		if got := b.String(); got != want {
+			t.Errorf("%v: want:\n%s\ngot:\n%s", tc.prop, want, got)
+		}
+		if got := file.String(); got != want {
+			t.Errorf("%v: String: want:\n%s\ngot:\n%s", tc.prop, want, got)
+		}
+		if _, err := file.MarshalText(); err == nil {
+			t.Errorf("%v: MarshalText did not error", tc.prop)
+		}
+	}
+}
+
+func TestPrintInvalidSections(t *testing.T) {
+	tests := []struct {
+		section Section
+		wantErr string
+	}{
+		{Section{Name: "*.go"}, ""},
+		{Section{Name: " spaced "}, ""},
+		{Section{Name: "*_test.go", Language: "go"}, ""},
+		{Section{Name: ""}, "cannot print a section with an empty name"},
+		{Section{Name: "a\nb"}, "name contains a newline"},
+		{Section{Name: "*", Language: "a]]b"}, "would not parse back the same"},
+		{Section{Name: " x ", Language: "go"}, "would not parse back the same"},
+		{Section{Name: "# tabs", Language: "go"}, "pattern starts like a comment"},
+	}
+	for _, tc := range tests {
+		tc.section.Add(Property{Name: "b", Value: "2"})
+		file := &File{Sections: []Section{section("a", "1"), tc.section, section("c", "3")}}
+		file.Sections[0].Name = "*"
+		file.Sections[2].Name = "*.md"
+		var b strings.Builder
+		_, err := file.WriteTo(&b)
+		if tc.wantErr == "" {
+			if err != nil {
+				t.Errorf("%#v: unexpected error: %v", tc.section, err)
+			}
+			continue
+		}
+		if err == nil || !strings.HasSuffix(err.Error(), tc.wantErr) {
+			t.Errorf("%#v: want error ending with %q, got %v", tc.section, tc.wantErr, err)
+		}
+		// The other sections are still printed, and they keep their
+		// properties.
+		want := "[*]\na=1\n\n[*.md]\nc=3\n"
+		if got := b.String(); got != want {
+			t.Errorf("%#v: want:\n%s\ngot:\n%s", tc.section, want, got)
+		}
+		if _, err := file.MarshalText(); err == nil {
+			t.Errorf("%#v: MarshalText did not error", tc.section)
+		}
+	}
+}
+
+func TestFileCommentWithoutRoot(t *testing.T) {
+	file := &File{Comment: "generated by mytool", Sections: []Section{section("a", "b")}}
+	file.Sections[0].Name = "*"
+	want := "# generated by mytool\n\n[*]\na=b\n"
+	if got := file.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+	parsed, err := ParseOptions{Comments: true}.Parse(strings.NewReader(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Comment != "# generated by mytool" || parsed.Root || parsed.Sections[0].Comment != "" {
+		t.Fatalf("want the comment to be the file's, got %#v", parsed)
+	}
+
+	// A comment directly before the first section belongs to it.
+	parsed, err = ParseOptions{Comments: true}.Parse(strings.NewReader("# go files\n[*.go]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Comment != "" || parsed.Sections[0].Comment != "# go files" {
+		t.Fatalf("want the comment to be the section's, got %#v", parsed)
+	}
+}
+
+func TestFileText(t *testing.T) {
+	type config struct {
+		EditorConfig *File
+	}
+	in := `{"EditorConfig":"root = true\n[*]\nindent_style = tab\n"}`
+	var c config
+	if err := json.Unmarshal([]byte(in), &c); err != nil {
+		t.Fatal(err)
+	}
+	if !c.EditorConfig.Root || c.EditorConfig.Sections[0].Get("indent_style") != "tab" {
+		t.Fatalf("unexpected file after unmarshaling:\n%s", c.EditorConfig)
+	}
+	out, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"EditorConfig":"root=true\n\n[*]\nindent_style=tab\n"}`
+	if got := string(out); got != want {
+		t.Fatalf("want %s, got %s", want, got)
+	}
+}
+
+func TestSectionJSON(t *testing.T) {
+	var s Section
+	s.Name = "*.go"
+	s.Add(
+		Property{Name: "indent_style", Value: "tab"},
+		Property{Name: "indent_size", Value: "8"},
+	)
+	out, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"name":"*.go","properties":{"indent_size":"8","indent_style":"tab"}}`
+	if got := string(out); got != want {
+		t.Fatalf("want %s, got %s", want, got)
+	}
+
+	var s2 Section
+	if err := json.Unmarshal(out, &s2); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s2.String(), s.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestFindAll(t *testing.T) {
+	opened := 0
+	fsys := fstest.MapFS{
+		".editorconfig": {Data: []byte("[*]\nindent_style = tab\n\n[*.md]\nindent_style = space\n")},
+	}
+	q := Query{FS: countingFS{fsys, &opened}}
+	sections, err := q.FindAll([]string{"a.go", "b.md", "sub/c.go", "sub/d.md"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, section := range sections {
+		got = append(got, section.Get("indent_style"))
+	}
+	if want := []string{"tab", "space", "tab", "space"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	// Only "." and "sub" should be opened, once each.
+	if opened != 2 {
+		t.Errorf("want 2 opened files, got %d", opened)
+	}
+}
+
+// countingFS counts the number of calls to Open.
+type countingFS struct {
+	fs.FS
+	n *int
+}
+
+func (c countingFS) Open(name string) (fs.File, error) {
+	*c.n++
+	return c.FS.Open(name)
+}
+
+func TestQueryWalkDir(t *testing.T) {
+	fsys := fstest.MapFS{
+		".editorconfig":       {Data: []byte("root = true\n[*]\nindent_style = space\n[*.go]\nindent_style = tab\n")},
+		"main.go":             {},
+		"README.md":           {},
+		"sub/.editorconfig":   {Data: []byte("[*.md]\ncharset = latin1\n")},
+		"sub/doc.md":          {},
+		"sub/skip/ignored.go": {},
+	}
+	opens := 0
+	q := Query{FS: fsys, Open: func(path string) (io.ReadCloser, error) {
+		opens++
+		return fsys.Open(path)
+	}}
+	var got []string
+	err := q.WalkDir(".", nil, func(path string, section Section) error {
+		if path == "sub/skip/ignored.go" {
+			return fs.SkipDir
+		}
+		got = append(got, fmt.Sprintf("%s: %s", path, strings.ReplaceAll(section.String(), "\n", " ")))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		".editorconfig: indent_style=space ",
+		"README.md: indent_style=space ",
+		"main.go: indent_size=tab indent_style=tab ",
+		"sub/.editorconfig: indent_style=space ",
+		"sub/doc.md: charset=latin1 indent_style=space ",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("want:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+	if opens != 3 {
+		t.Errorf("want one open per directory, got %d", opens)
+	}
+
+	errStop := errors.New("stop")
+	err = Query{FS: fsys}.WalkDir(".", nil, func(string, Section) error { return errStop })
+	if err != errStop {
+		t.Fatalf("want the error from fn, got %v", err)
+	}
+}
+
+func TestQueryWalkDirStartDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, ".editorconfig"), "root = true\n[*.go]\nindent_style = tab\n")
+	writeFile(t, filepath.Join(dir, "sub", "main.go"), "")
+
+	for _, root := range []string{".", "sub"} {
+		var got []string
+		err := Query{StartDir: dir}.WalkDir(root, nil, func(path string, section Section) error {
+			got = append(got, fmt.Sprintf("%s: %s", filepath.ToSlash(path), strings.ReplaceAll(section.String(), "\n", " ")))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"sub/main.go: indent_size=tab indent_style=tab "}
+		if root == "." {
+			want = append([]string{".editorconfig: "}, want...)
+		}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("WalkDir(%q): want:\n%s\ngot:\n%s", root, strings.Join(want, "\n"), strings.Join(got, "\n"))
+		}
+	}
+}
+
+func TestFindSources(t *testing.T) {
+	fsys := fstest.MapFS{
+		".editorconfig":     {Data: []byte("root = true\n[*]\nindent_style = tab\ncharset = utf-8\n[*.go]\nindent_size = 8\n")},
+		"sub/.editorconfig": {Data: []byte("[*.go]\ncharset = latin1\n[*]\nindent_style = unset\n")},
+	}
+	section, sources, err := Query{FS: fsys}.FindSources("sub/main.go", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "charset=latin1\nindent_size=8\ntab_width=8\n"
+	if got := section.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+	wantSources := []Source{
+		{Property: "charset", Path: "sub/.editorconfig", Section: 0},
+		{Property: "indent_size", Path: ".editorconfig", Section: 1},
+	}
+	if fmt.Sprint(sources) != fmt.Sprint(wantSources) {
+		t.Fatalf("want sources %v, got %v", wantSources, sources)
+	}
+}
+
+func TestQueryRootDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, ".editorconfig"), "[*]\ncharset = latin1\n")
+	writeFile(t, filepath.Join(dir, "repo", "sub", ".editorconfig"), "[*]\nindent_style = tab\n")
+
+	q := Query{RootDir: filepath.Join(dir, "repo")}
+	section, err := q.Find(filepath.Join(dir, "repo", "sub", "main.go"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "indent_size=tab\nindent_style=tab\n"
+	if got := section.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+
+	fsys := fstest.MapFS{
+		".editorconfig":          {Data: []byte("[*]\ncharset = latin1\n")},
+		"repo/.editorconfig":     {Data: []byte("[*]\nend_of_line = lf\n")},
+		"repo/sub/.editorconfig": {Data: []byte("[*]\nindent_style = tab\n")},
+	}
+	q = Query{FS: fsys, RootDir: "repo/"}
+	if section, err = q.Find("repo/sub/main.go", nil); err != nil {
+		t.Fatal(err)
+	}
+	want = "end_of_line=lf\nindent_size=tab\nindent_style=tab\n"
+	if got := section.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestQueryStartDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, ".editorconfig"), "[*]\ncharset = latin1\n")
+	writeFile(t, filepath.Join(dir, "repo", "sub", ".editorconfig"), "[*.go]\nindent_style = tab\n")
+
+	q := Query{StartDir: filepath.Join(dir, "repo"), RootDir: "."}
+	section, err := q.Find(filepath.Join("sub", "main.go"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "indent_size=tab\nindent_style=tab\n"
+	if got := section.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+
+	// Absolute names ignore StartDir.
+	q = Query{StartDir: filepath.Join(dir, "repo")}
+	if section, err = q.Find(filepath.Join(dir, "main.go"), nil); err != nil {
+		t.Fatal(err)
+	}
+	want = "charset=latin1\n"
+	if got := section.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestFindDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, ".editorconfig"), "root = true\n\n[*]\ncharset = latin1\n\n[sub]\nindent_style = tab\n")
+	writeFile(t, filepath.Join(dir, "sub", ".editorconfig"), "[*]\nend_of_line = lf\n")
+
+	// A directory is treated as a file within its parent directory, so
+	// its own EditorConfig file is not used.
+	want := "charset=latin1\nindent_size=tab\nindent_style=tab\n"
+	for _, name := range []string{
+		filepath.Join(dir, "sub"),
+		filepath.Join(dir, "sub") + string(filepath.Separator),
+	} {
+		section, err := Query{}.Find(name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := section.String(); got != want {
+			t.Fatalf("Find(%q): want:\n%s\ngot:\n%s", name, want, got)
+		}
+	}
+
+	fsys := fstest.MapFS{
+		".editorconfig":     {Data: []byte("[*]\ncharset = latin1\n\n[sub]\nindent_style = tab\n")},
+		"sub/.editorconfig": {Data: []byte("[*]\nend_of_line = lf\n")},
+	}
+	section, err := Query{FS: fsys}.Find("sub", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := section.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+	if _, err := (Query{FS: fsys}).Find("sub/", nil); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("want fs.ErrInvalid with a trailing slash, got %v", err)
+	}
+}
+
+func TestQueryFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, ".editorconfig"), "[*]\nindent_style = tab\n")
+	writeFile(t, filepath.Join(dir, ".altconfig"), "[*]\nindent_style = space\n")
+	name := filepath.Join(dir, "main.go")
+
+	for _, tc := range []struct {
+		env, want string
+	}{
+		{"", "tab"},
+		{".altconfig", "space"},
+	} {
+		t.Setenv(ConfigNameEnv, tc.env)
+		q := QueryFromEnv()
+		q.RootDir = dir
+		section, err := q.Find(name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := section.Get("indent_style"); got != tc.want {
+			t.Errorf("%s=%q: want indent_style=%s, got %q", ConfigNameEnv, tc.env, tc.want, got)
+		}
+	}
+}
+
+func TestQueryAffects(t *testing.T) {
+	fsys := fstest.MapFS{
+		".editorconfig":        {Data: []byte("[*]\nindent_style = tab\n[*.md]\ntrim_trailing_whitespace = false\n[*.txt]\ncharset = utf-8\n")},
+		"sub/.editorconfig":    {Data: []byte("[*.txt]\ncharset = unset\n")},
+		"broken/.editorconfig": {Data: []byte("[a[b]\ncharset = utf-8\n")},
+	}
+	tests := []struct {
+		query Query
+		name  string
+		want  bool
+	}{
+		{Query{FS: fsys}, "main.go", false},
+		{Query{FS: fsys}, "README.md", true},
+		{Query{FS: fsys}, "notes.txt", true},
+		{Query{FS: fsys}, "sub/notes.txt", false},
+		{Query{FS: fsys, Defaults: []Property{{Name: "end_of_line", Value: "lf"}}}, "main.go", true},
+		{Query{FS: fsys, Defaults: []Property{{Name: "charset", Value: "utf-8"}}}, "sub/notes.txt", false},
+	}
+	for _, tc := range tests {
+		got, err := tc.query.Affects(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("Affects(%q): want %t, got %t", tc.name, tc.want, got)
+		}
+	}
+	if _, err := (Query{FS: fsys}).Affects("broken/main.go"); err == nil {
+		t.Errorf("want an error for an invalid pattern")
+	}
+}
+
+func TestQueryConfigFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		".editorconfig":          {Data: []byte("[*]\ncharset = latin1\n")},
+		"repo/.editorconfig":     {Data: []byte("root = true\n")},
+		"repo/sub/.editorconfig": {Data: []byte("[*.go]\nindent_style = tab\n")},
+		"repo/sub/dir/main.go":   {},
+	}
+	tests := []struct {
+		query Query
+		name  string
+		want  []string
+	}{
+		{Query{FS: fsys}, "repo/sub/dir/main.go", []string{"repo/sub/.editorconfig", "repo/.editorconfig"}},
+		{Query{FS: fsys}, "main.go", []string{".editorconfig"}},
+		{Query{FS: fsys, RootDir: "repo/sub"}, "repo/sub/main.go", []string{"repo/sub/.editorconfig"}},
+		{Query{FS: fsys, ConfigName: ".other"}, "repo/main.go", nil},
+		{Query{FS: fsys, MaxDepth: 1}, "repo/sub/dir/main.go", nil},
+		{Query{FS: fsys, MaxDepth: 2}, "repo/sub/dir/main.go", []string{"repo/sub/.editorconfig"}},
+		{Query{FS: fsys, MaxDepth: 4}, "repo/sub/dir/main.go", []string{"repo/sub/.editorconfig", "repo/.editorconfig"}},
+	}
+	for _, tc := range tests {
+		got, err := tc.query.ConfigFiles(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: want %q, got %q", tc.name, tc.want, got)
+		}
+	}
+	if _, err := (Query{FS: fsys}).ConfigFiles("/abs"); err == nil {
+		t.Errorf("want an error for an invalid path")
+	}
+}
+
+func TestQueryResolveSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "real", ".editorconfig"), "[*]\nindent_style = tab\n")
+	writeFile(t, filepath.Join(dir, "real", "main.go"), "")
+	if err := os.MkdirAll(filepath.Join(dir, "links"), 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "real"), filepath.Join(dir, "links", "real")); err != nil {
+		t.Skipf("cannot create symlinks: %v", err)
+	}
+
+	for _, name := range []string{"main.go", "new.go"} {
+		name = filepath.Join(dir, "links", "real", name)
+		section, err := Query{}.Find(name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := section.Get("indent_style"); got != "tab" {
+			t.Errorf("%s: want indent_style=tab, got %q", name, got)
+		}
+		section, err = Query{ResolveSymlinks: true}.Find(name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := section.Get("indent_style"); got != "tab" {
+			t.Errorf("%s: want indent_style=tab with ResolveSymlinks, got %q", name, got)
+		}
+	}
+
+	// A config in the symlink's parent directory only applies when not
+	// resolving symlinks.
+	writeFile(t, filepath.Join(dir, "links", ".editorconfig"), "[*]\ncharset = latin1\n")
+	name := filepath.Join(dir, "links", "real", "main.go")
+	section, err := Query{}.Find(name, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := section.Get("charset"); got != "latin1" {
+		t.Errorf("want charset=latin1, got %q", got)
+	}
+	section, err = Query{ResolveSymlinks: true}.Find(name, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := section.Get("charset"); got != "" {
+		t.Errorf("want no charset with ResolveSymlinks, got %q", got)
+	}
+
+	// RootDir is resolved too, so a RootDir through a symlink still stops
+	// the search, and Invalidate works with the symlink's path.
+	writeFile(t, filepath.Join(dir, ".editorconfig"), "[*]\nend_of_line = crlf\n")
+	fileCache := make(map[string]*File)
+	q := Query{
+		ResolveSymlinks: true,
+		RootDir:         filepath.Join(dir, "links", "real"),
+		FileCache:       fileCache,
+	}
+	if section, err = q.Find(name, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := section.Get("end_of_line"); got != "" {
+		t.Errorf("want no end_of_line above RootDir with ResolveSymlinks, got %q", got)
+	}
+	if len(fileCache) != 1 {
+		t.Errorf("want one cached directory with RootDir, got %d", len(fileCache))
+	}
+	q.Invalidate(filepath.Join(dir, "links", "real"))
+	if len(fileCache) != 0 {
+		t.Errorf("Invalidate via a symlink did not remove the resolved directory")
+	}
+}
+
+func TestQueryOpen(t *testing.T) {
+	dir := t.TempDir()
+	var opened []string
+	q := Query{
+		RootDir: dir,
+		Open: func(path string) (io.ReadCloser, error) {
+			opened = append(opened, path)
+			if path == filepath.Join(dir, ".editorconfig") {
+				return io.NopCloser(strings.NewReader("[*]\nindent_style = tab\n")), nil
+			}
+			return nil, fs.ErrNotExist
+		},
+	}
+	section, err := q.Find(filepath.Join(dir, "sub", "main.go"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := section.Get("indent_style"); got != "tab" {
+		t.Errorf("want indent_style=tab, got %q", got)
+	}
+	want := []string{filepath.Join(dir, "sub", ".editorconfig"), filepath.Join(dir, ".editorconfig")}
+	if fmt.Sprint(opened) != fmt.Sprint(want) {
+		t.Errorf("want opened files %q, got %q", want, opened)
+	}
+}
+
+func TestFileCompile(t *testing.T) {
+	file, err := Parse(strings.NewReader("[*]\nend_of_line = lf\n[[go]]\nindent_style = tab\n[*_test.go]\nindent_size = 4\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			want := "end_of_line=lf\nindent_size=4\nindent_style=tab\n"
+			if got := file.Filter("main_test.go", []string{"go"}, nil).String(); got != want {
+				t.Errorf("want:\n%s\ngot:\n%s", want, got)
+			}
+		}()
+	}
+	wg.Wait()
+
+	file.Sections[2].Name = "*.go"
+	if got := file.Filter("main.go", nil, nil).Get("indent_size"); got != "4" {
+		t.Errorf("renamed section did not match: got indent_size=%q", got)
+	}
+
+	file, err = Parse(strings.NewReader("[a[b]\nindent_size = 2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Compile(); err == nil {
+		t.Fatal("Compile with an invalid pattern did not error")
+	}
+}
+
+func TestIsLanguage(t *testing.T) {
+	tests := []struct {
+		name string
+		lang string
+		ok   bool
+	}{
+		{"[go]", "go", true},
+		{"[shell]", "shell", true},
+		{"*.go", "", false},
+		{"[]", "", false},
+		{"[abc]", "abc", true},
+		{"[[:digit:]]", "", false},
+		{"[[:digit:]][!a]", "", false},
+		{"[a]b[c]", "", false},
+		{"[go] *_test.go", "", false},
+		{"[go]*_test.go", "", false},
+		{"[abc].go", "", false},
+	}
+	for _, tc := range tests {
+		lang, ok := Section{Name: tc.name}.IsLanguage()
+		if lang != tc.lang || ok != tc.ok {
+			t.Errorf("IsLanguage of %q: want (%q, %t), got (%q, %t)", tc.name, tc.lang, tc.ok, lang, ok)
+		}
+	}
+	if lang, ok := (Section{Name: "*_test.go", Language: "go"}).IsLanguage(); lang != "go" || !ok {
+		t.Errorf("IsLanguage with Language set: want (%q, true), got (%q, %t)", "go", lang, ok)
+	}
+}
+
+func TestLanguagePattern(t *testing.T) {
+	file, err := Parse(strings.NewReader(`
+[*]
+indent_style = space
+
+[[go]]
+indent_style = tab
+
+[[go]] *_test.go
+indent_size = 4
+
+[[go]] testdata/*.[ch]
+indent_size = 2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := file.Sections[2]; got.Name != "*_test.go" || got.Language != "go" {
+		t.Fatalf("want the name %q and language %q, got %#v", "*_test.go", "go", got)
+	}
+	tests := []struct {
+		name  string
+		langs []string
+		want  string
+	}{
+		{"main.go", []string{"go"}, "indent_style=tab\n"},
+		{"main_test.go", []string{"go"}, "indent_size=4\nindent_style=tab\n"},
+		{"main_test.go", nil, "indent_style=space\n"},
+		{"testdata/x.c", []string{"go"}, "indent_size=2\nindent_style=tab\n"},
+	}
+	for _, tc := range tests {
+		if got := file.Filter(tc.name, tc.langs, nil).String(); got != tc.want {
+			t.Errorf("Filter(%q, %q): want %q, got %q", tc.name, tc.langs, tc.want, got)
+		}
+	}
+	if err := file.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	if got := file.Filter("main_test.go", []string{"go"}, nil).Get("indent_size"); got != "4" {
+		t.Errorf("compiled language pattern did not match: got indent_size=%q", got)
+	}
+
+	// The combined headers are printed back as they were parsed.
+	want := "[*]\nindent_style=space\n\n[[go]]\nindent_style=tab\n\n[[go]] *_test.go\nindent_size=4\n\n[[go]] testdata/*.[ch]\nindent_size=2\n"
+	if got := file.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+
+	// Normalize does not merge a language section with a plain section
+	// which has the same pattern.
+	file.Sections = append(file.Sections, Section{Name: "*_test.go"})
+	if got := len(file.Normalize().Sections); got != 5 {
+		t.Fatalf("want 5 sections after Normalize, got %d", got)
+	}
+
+	// A pattern which starts like a comment is not allowed, as it is
+	// likely a comment which the spec does not allow after a header.
+	src := "[[go]] # tabs\nindent_style = tab\n"
+	file, warnings, err := ParseWithWarnings(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Sections) != 0 || len(warnings) != 1 {
+		t.Fatalf("want the section to be ignored with a warning, got %#v and %v", file.Sections, warnings)
+	}
+	var perr *ParseError
+	if _, err := (ParseOptions{Strict: true}).Parse(strings.NewReader(src)); !errors.As(err, &perr) || perr.Line != 1 {
+		t.Fatalf("want a ParseError on line 1 with Strict, got %v", err)
+	}
+}
+
+func TestBracketPatternNotLanguage(t *testing.T) {
+	// A glob header which starts with a bracket expression is not a
+	// language section, even if its name looks like one with a pattern.
+	in := "[[ab] c]\ny=2\n"
+	file, err := Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := file.Sections[0]; got.Name != "[ab] c" || got.Language != "" {
+		t.Fatalf("want the name %q and no language, got %#v", "[ab] c", got)
+	}
+	if got := file.Filter("a c", nil, nil).String(); got != "y=2\n" {
+		t.Fatalf("want y=2 for %q, got %q", "a c", got)
+	}
+	if got := file.String(); got != in {
+		t.Fatalf("want:\n%s\ngot:\n%s", in, got)
+	}
+}
+
+func TestSectionRegexp(t *testing.T) {
+	s := Section{Name: "*.{go,mod}"}
+	rx, err := s.Regexp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{"main.go": true, "sub/go.mod": true, "main.c": false} {
+		if got := rx.MatchString(name); got != want {
+			t.Errorf("%s on %q: want %t, got %t", rx, name, want, got)
+		}
+	}
+	if again, _ := s.Regexp(); again != rx {
+		t.Errorf("Regexp compiled the same pattern twice")
+	}
+	s.Name = "*.c"
+	if again, _ := s.Regexp(); again == rx || !again.MatchString("main.c") {
+		t.Errorf("Regexp did not follow the renamed section")
+	}
+
+	if rx, err := (&Section{Name: "[go]"}).Regexp(); rx != nil || err != nil {
+		t.Errorf("Regexp of a language section: want nil, got %v, %v", rx, err)
+	}
+	if _, err := (&Section{Name: "a[b"}).Regexp(); err == nil {
+		t.Errorf("Regexp of an invalid pattern did not error")
+	}
+}
+
+func TestSpecificity(t *testing.T) {
+	tests := []struct {
+		name string
+		want int
+	}{
+		{"*", 0},
+		{"**", 0},
+		{"*.go", 3},
+		{"main.go", 7},
+		{"src/**/*.go", 8},
+		{"*.{go,mod}", 3},
+		{"*.{js,{jsx,json}}", 3},
+		{"[abc].go", 3},
+		{"[!]].go", 3},
+		{"file?.log", 8},
+		{`a\*b`, 3},
+		{"{1..10}.txt", 5},
+		{"{single}", 8},
+		{"a[b", 3},
+		{"[go]", 0},
+	}
+	for _, tc := range tests {
+		if got := (Section{Name: tc.name}).Specificity(); got != tc.want {
+			t.Errorf("Specificity of %q: want %d, got %d", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestParseWithWarnings(t *testing.T) {
+	src := strings.Join([]string{
+		"root = true",
+		"indent_style = tab",
+		"[*]",
+		strings.Repeat("k", MaxPropertyKeyLength+1) + " = v",
+		"name = " + strings.Repeat("v", MaxPropertyValueLength+1),
+		"end_of_line = lf",
+		"root = true",
+		"[" + strings.Repeat("*", MaxSectionNameLength+1) + "]",
+		"charset = utf-8",
+	}, "\n")
+	file, warnings, err := ParseWithWarnings(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "root=true\n\n[*]\nend_of_line=lf\nroot=true\n"
+	if got := file.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+	var got []string
+	for _, w := range warnings {
+		got = append(got, w.String())
+	}
+	wantWarnings := []string{
+		`line 2: property "indent_style" appears before any section`,
+		`line 4: property key is longer than 1024 bytes`,
+		`line 5: value of property "name" is longer than 4096 bytes`,
+		`line 7: property "root" appears inside a section; it only has an effect before any section`,
+		`line 8: section name is longer than 4096 bytes; ignoring the section`,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(wantWarnings) {
+		t.Fatalf("want warnings:\n%s\ngot:\n%s", strings.Join(wantWarnings, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestParseMaxLengths(t *testing.T) {
+	long := strings.Repeat("v", 10000)
+	src := "[*]\nkey = " + long + "\nother = v\n"
+	file, warnings, err := ParseWithWarnings(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || file.Sections[0].Has("key") || !file.Sections[0].Has("other") {
+		t.Fatalf("want the long value to be ignored with a warning, got %q and %v", file, warnings)
+	}
+
+	opts := ParseOptions{MaxPropertyValueLength: len(long), MaxPropertyKeyLength: 3}
+	file, warnings, err = opts.ParseWithWarnings(strings.NewReader(src + "long_key = v\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := file.Sections[0].Get("key"); got != long {
+		t.Fatalf("want the long value to be kept, got %d bytes", len(got))
+	}
+	if len(warnings) != 2 || warnings[0].Line != 3 || warnings[1].Line != 4 {
+		t.Fatalf("want warnings for lines 3 and 4, got %v", warnings)
+	}
+}
+
+func TestParseLongLines(t *testing.T) {
+	huge := strings.Repeat("x", 1<<20)
+	src := strings.Join([]string{
+		"[*]",
+		"key = " + huge,
+		"indent_style = tab",
+		"[" + huge + "]",
+		"ignored = true",
+		"[*.go]\r" + huge + "\r\nindent_size = 4",
+	}, "\n")
+	file, warnings, err := ParseWithWarnings(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[*]\nindent_style=tab\n\n[*.go]\nindent_size=4\n"
+	if got := file.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+	var lines []int
+	for _, w := range warnings {
+		lines = append(lines, w.Line)
+	}
+	if want := "[2 4 7]"; fmt.Sprint(lines) != want {
+		t.Fatalf("want warnings on lines %s, got %v", want, warnings)
+	}
+}
+
+func TestParseLineEndings(t *testing.T) {
+	want := "root=true\n\n[*.go]\nindent_style=tab\ntrim_trailing_whitespace=true\n"
+	for _, eol := range []string{"\n", "\r\n", "\r"} {
+		src := strings.Join([]string{
+			"root = true", "[*.go]", "indent_style = tab", "trim_trailing_whitespace = true", "",
+		}, eol)
+		file, err := ParseOptions{Strict: true}.Parse(strings.NewReader(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := file.String(); got != want {
+			t.Errorf("with %q line endings, want:\n%s\ngot:\n%s", eol, want, got)
+		}
+		if !file.Sections[0].TrimTrailingWhitespace() {
+			t.Errorf("with %q line endings, TrimTrailingWhitespace returned false", eol)
+		}
+	}
+
+	// A "\r" split from its "\n" by the reader's buffering.
+	r := io.MultiReader(strings.NewReader("[*]\r"), strings.NewReader("\nend_of_line = lf\r\n"))
+	file, err := ParseOptions{Strict: true}.Parse(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := file.Sections[0].Get("end_of_line"); got != "lf" {
+		t.Errorf("want end_of_line=lf, got %q", got)
+	}
+}
+
+func TestParseComments(t *testing.T) {
+	// Like the comment cases in the core tests, only whole lines starting
+	// with "#" or ";" are comments.
+	file, err := Parse(strings.NewReader(`
+# a comment
+; another comment
+[*.c]
+  # an indented comment
+key1 = value ; not a comment
+key2 = value # not a comment
+url = https://example.com/ #anchor
+key3 = #value
+[*.h] # not a section
+key4 = value
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[*.c]\nkey1=value ; not a comment\nkey2=value # not a comment\nkey3=#value\nkey4=value\nurl=https://example.com/ #anchor\n"
+	if got := file.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestParseDuplicateKeys(t *testing.T) {
+	file, err := ParseOptions{Comments: true, KeepOrder: true}.Parse(strings.NewReader(`
+[*]
+# the size
+indent_size = 2
+indent_style = tab
+# overridden
+INDENT_SIZE = 4
+
+[*.go]
+indent_size = 8
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[*]\n# the size\n# overridden\nindent_size=4\nindent_style=tab\n\n[*.go]\nindent_size=8\n"
+	if got := file.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+	reparsed, err := ParseOptions{Comments: true}.Parse(strings.NewReader(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reparsed.String(); got != want {
+		t.Fatalf("reparsing changed the output:\n%s", got)
+	}
+	// Across sections, the later section still takes precedence.
+	if got := file.Filter("main.go", nil, nil).Get("indent_size"); got != "8" {
+		t.Fatalf("want indent_size=8 for main.go, got %q", got)
+	}
+	if got := file.Filter("main.js", nil, nil).Get("indent_size"); got != "4" {
+		t.Fatalf("want indent_size=4 for main.js, got %q", got)
+	}
+}
+
+func TestParseSeparators(t *testing.T) {
+	// The key ends at the first "=" or ":", and the value is the rest.
+	tests := []struct {
+		line       string
+		equalsOnly bool
+		key, value string // empty if the line is ignored
+	}{
+		{"foo = a=b:c", false, "foo", "a=b:c"},
+		{"foo : a=b:c", false, "foo", "a=b:c"},
+		{`path = C:\Users\gopher`, false, "path", `C:\Users\gopher`},
+		{`path: C:\Users\gopher`, false, "path", `C:\Users\gopher`},
+		{"foo:bar = baz", false, "foo", "bar = baz"},
+		{"foo==", false, "foo", "="},
+		{"foo =", false, "foo", ""},
+
+		{"foo = a=b:c", true, "foo", "a=b:c"},
+		{"foo : a=b:c", true, "foo : a", "b:c"},
+		{"foo:bar = baz", true, "foo:bar", "baz"},
+		{`C:\Users\gopher = path`, true, `c:\users\gopher`, "path"},
+		{`path: C:\Users\gopher`, true, "", ""},
+	}
+	for _, tc := range tests {
+		opts := ParseOptions{EqualsOnly: tc.equalsOnly}
+		file, err := opts.Parse(strings.NewReader("[*]\n" + tc.line + "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		props := file.Sections[0].Properties
+		if tc.key == "" {
+			if len(props) != 0 {
+				t.Errorf("%q with %+v: want no properties, got %v", tc.line, opts, props)
+			}
+			continue
+		}
+		if len(props) != 1 || props[0].Name != tc.key || props[0].Value != tc.value {
+			t.Errorf("%q with %+v: want %s=%s, got %v", tc.line, opts, tc.key, tc.value, props)
+		}
+	}
+
+	_, err := ParseOptions{Strict: true, EqualsOnly: true}.Parse(strings.NewReader("[*]\nfoo: bar\n"))
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Msg != "expected a key-value pair separated by =" {
+		t.Errorf("want a ParseError for a line without =, got %v", err)
+	}
+}
+
+func TestParseBOM(t *testing.T) {
+	file, err := Parse(strings.NewReader("\ufeffroot = true\n[*]\nindent_style = tab\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !file.Root {
+		t.Fatal("root = true after a byte order mark was ignored")
+	}
+}
+
+var matchTests = []struct {
+	pattern string
+	name    string
+	want    bool
+}{
+	// Escaped special characters match literally.
+	{`a\*b`, "a*b", true},
+	{`a\*b`, "axb", false},
+	{`a\?b`, "a?b", true},
+	{`a\?b`, "axb", false},
+	{`\[ab\]`, "[ab]", true},
+	{`\[ab\]`, "a", false},
+	{`\{a,b\}`, "{a,b}", true},
+	{`\{a,b\}`, "a", false},
+
+	// Negated character classes, which don't match slashes.
+	{`*.[!j]s`, "a.ts", true},
+	{`*.[!j]s`, "a.js", false},
+	{`*.[^j]s`, "a.ts", true},
+	{`*.[^j]s`, "a.js", false},
+	{`a[!b]c`, "a/c", false},
+	{`a[!b]c`, "axc", true},
+	{`[!]]x`, "]x", false},
+	{`[!]]x`, "ax", true},
+	{`a\[!b]`, "a[!b]", true},
+	{`[[:digit:]][!a]`, "1b", true},
+	{`[[:digit:]][!a]`, "1a", false},
+	{`[[:digit:]][!a]`, "1/", false},
+
+	// "**" matches any number of path segments, including none.
+	{`a/**/b`, "a/b", true},
+	{`a/**/b`, "a/x/b", true},
+	{`a/**/b`, "a/x/y/z/b", true},
+	{`a/**/b`, "a/xb", false},
+	{`a/**/b`, "ab", false},
+	{`a/**/b`, "a/x/b/c", false},
+	{`a/**`, "a/b/c", true},
+	{`a/**`, "ab", false},
+	{`/**/b.go`, "b.go", true},
+	{`/**/b.go`, "x/y/b.go", true},
+	{`**/b.go`, "x/y/b.go", true},
+	{`a**b`, "a/x/b", true},
+	{`a*b`, "a/x/b", false},
+	{`*.go`, "x/y/z/b.go", true},
+
+	// Patterns with a slash are anchored to the EditorConfig's directory.
+	{`/build`, "build", true},
+	{`/build`, "sub/build", false},
+	{`/build`, "build/main.go", false},
+	{`build`, "sub/build", true},
+	{`docs/*.md`, "docs/a.md", true},
+	{`docs/*.md`, "sub/docs/a.md", false},
+	{`docs/*.md`, "docs/sub/a.md", false},
+	{`/docs/*.md`, "docs/a.md", true},
+	{`docs/`, "docs", false},
+	{`docs/`, "docs/a.md", false},
+	{`docs/**`, "docs/sub/a.md", true},
+	{`docs/**`, "sub/docs/a.md", false},
+
+	// Numeric ranges match whole integers, optionally padded with zeros.
+	{`{1..10}.txt`, "5.txt", true},
+	{`{1..10}.txt`, "10.txt", true},
+	{`{1..10}.txt`, "11.txt", false},
+	{`file{1..9}.log`, "logs/file5.log", true},
+	{`{3..120}`, "60", true},
+	{`{3..120}`, "060", false},
+	{`{3..120}`, "121", false},
+	{`{-3..3}`, "-2", true},
+	{`{-3..3}`, "4", false},
+	{`{01..10}.txt`, "05.txt", true},
+	{`{01..10}.txt`, "10.txt", true},
+	{`{01..10}.txt`, "5.txt", false},
+	{`{007..009}`, "008", true},
+	{`{1..010}`, "003", true},
+	{`{05..05}`, "05", true},
+	{`\{01..10}`, "{01..10}", true},
+	{`{3..1}`, "2", true},
+	{`{3..1}`, "4", false},
+	{`{10..01}`, "05", true},
+	{`{10..01}`, "5", false},
+	{`{1..-2}`, "-1", true},
+	{`{0..999}`, "998", true},
+}
+
+func TestMatchPatterns(t *testing.T) {
+	for _, tc := range matchTests {
+		got, err := Section{Name: tc.pattern}.MatchError(tc.name)
+		if err != nil {
+			t.Errorf("[%s] on %q: unexpected error: %v", tc.pattern, tc.name, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("[%s] on %q: want %t, got %t", tc.pattern, tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestLRUCache(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a/.editorconfig": {Data: []byte("[*]\nindent_style = tab\n")},
+		"b/.editorconfig": {Data: []byte("[*]\nindent_style = space\n")},
+	}
+	cache := &LRUCache{MaxEntries: 3}
+	q := Query{FS: fsys, Cache: cache}
+	for _, name := range []string{"a/main.go", "b/main.go", "a/main.go"} {
+		if _, err := q.Find(name, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := cache.Len(); got > 3 {
+			t.Fatalf("cache grew to %d entries, want at most 3", got)
+		}
+	}
+	// The last Find used "a" and "[*]", so "b" was the least recently used.
+	if _, ok := cache.LoadFile("a"); !ok {
+		t.Errorf("recently used directory a was evicted")
+	}
+	if _, ok := cache.LoadFile("b"); ok {
+		t.Errorf("least recently used directory b was not evicted")
+	}
+
+	var unbounded LRUCache
+	for i := 0; i < 10; i++ {
+		unbounded.StoreFile(fmt.Sprint(i), nil)
+	}
+	if got := unbounded.Len(); got != 10 {
+		t.Errorf("zero LRUCache holds %d entries, want 10", got)
+	}
+}
+
+func TestQueryInvalidate(t *testing.T) {
+	for _, cache := range []Cache{new(SyncCache), new(LRUCache)} {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "a", ".editorconfig"), "[*]\nindent_style = tab\n")
+		writeFile(t, filepath.Join(dir, "b", ".editorconfig"), "[*]\nindent_style = tab\n")
+
+		q := Query{Cache: cache, StartDir: dir, RootDir: "."}
+		find := func(name string) string {
+			t.Helper()
+			section, err := q.Find(name, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return section.Get("indent_style")
+		}
+		find(filepath.Join("a", "main.go"))
+		find(filepath.Join("b", "main.go"))
+
+		writeFile(t, filepath.Join(dir, "a", ".editorconfig"), "[*]\nindent_style = space\n")
+		writeFile(t, filepath.Join(dir, "b", ".editorconfig"), "[*]\nindent_style = space\n")
+		q.Invalidate("a")
+		if got := find(filepath.Join("a", "main.go")); got != "space" {
+			t.Errorf("%T: want the changed indent_style in a, got %q", cache, got)
+		}
+		if got := find(filepath.Join("b", "main.go")); got != "tab" {
+			t.Errorf("%T: want the cached indent_style in b, got %q", cache, got)
+		}
+	}
+
+	fileCache := make(map[string]*File)
+	q := Query{FS: fstest.MapFS{}, FileCache: fileCache}
+	if _, err := q.Find("a/main.go", nil); err != nil {
+		t.Fatal(err)
+	}
+	q.Invalidate("a/")
+	if _, ok := fileCache["a"]; ok {
+		t.Errorf("Invalidate did not remove the entry from FileCache")
+	}
+	if _, ok := fileCache["."]; !ok {
+		t.Errorf("Invalidate removed an unrelated entry from FileCache")
+	}
+}
+
+func TestQueryPrewarm(t *testing.T) {
+	fsys := fstest.MapFS{
+		".editorconfig":         {Data: []byte("[*]\ncharset = utf-8\n")},
+		"sub/dir/.editorconfig": {Data: []byte("[*.go]\nindent_style = tab\n")},
+	}
+	opened := 0
+	q := Query{
+		FS:    fsys,
+		Cache: new(SyncCache),
+		Open: func(path string) (io.ReadCloser, error) {
+			opened++
+			return fsys.Open(path)
+		},
+	}
+	if err := q.Prewarm([]string{".", "sub", "sub/dir/"}); err != nil {
+		t.Fatal(err)
+	}
+	if opened != 3 {
+		t.Fatalf("want Prewarm to open 3 files, got %d", opened)
+	}
+	opened = 0
+	section, err := q.Find("sub/dir/main.go", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "charset=utf-8\nindent_size=tab\nindent_style=tab\n"; section.String() != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, section)
+	}
+	if opened != 0 {
+		t.Fatalf("want Find after Prewarm to open no files, got %d", opened)
+	}
+
+	fsys["bad/.editorconfig"] = &fstest.MapFile{Data: []byte("[*]\nbad line\n")}
+	q.Strict = true
+	if err := q.Prewarm([]string{"bad"}); err == nil {
+		t.Fatalf("Prewarm with a malformed file did not error")
+	}
+}
+
+func TestQueryCaseInsensitive(t *testing.T) {
+	fsys := fstest.MapFS{
+		".editorconfig": {Data: []byte("[*.go]\nindent_style = tab\n")},
+	}
+	cache := new(SyncCache)
+	for _, tc := range []struct {
+		fold bool
+		want string
+	}{
+		{false, ""},
+		{true, "tab"},
+		{false, ""}, // the cached case-insensitive regexp is not reused
+	} {
+		q := Query{FS: fsys, Cache: cache, CaseInsensitive: tc.fold}
+		section, err := q.Find("Main.GO", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := section.Get("indent_style"); got != tc.want {
+			t.Errorf("CaseInsensitive=%t: want indent_style=%q, got %q", tc.fold, tc.want, got)
+		}
+	}
+}
+
+func TestSectionDiff(t *testing.T) {
+	a := section("charset", "utf-8", "indent_size", "4", "indent_style", "space")
+	b := section("end_of_line", "lf", "indent_size", "8", "indent_style", "space")
+	want := []PropertyChange{
+		{Name: "charset", Old: "utf-8"},
+		{Name: "end_of_line", New: "lf"},
+		{Name: "indent_size", Old: "4", New: "8"},
+	}
+	if got := a.Diff(b); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	if got := a.Diff(a); len(got) != 0 {
+		t.Fatalf("want no changes, got %v", got)
+	}
+}
+
+func TestSectionEqual(t *testing.T) {
+	a := section("charset", "utf-8", "indent_size", "4")
+	a.Name = "*.go"
+	b := Section{Name: "*.go"}
+	b.Add(Property{Name: "indent_size", Value: "4", Comment: "# four"}, Property{Name: "charset", Value: "utf-8"})
+	if _, err := b.Regexp(); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Equal(b) || !b.Equal(a) {
+		t.Errorf("want %q and %q to be equal", a, b)
+	}
+
+	for _, c := range []Section{
+		{Name: "*.md", Properties: a.Properties},
+		{Name: "*.go", Properties: a.Properties[:1]},
+		{Name: "*.go", Properties: []Property{{Name: "charset", Value: "utf-8"}, {Name: "indent_size", Value: "2"}}},
+		{Name: "*.go", Properties: []Property{{Name: "charset", Value: "utf-8"}, {Name: "tab_width", Value: "4"}}},
+	} {
+		if a.Equal(c) {
+			t.Errorf("want %q and %q to not be equal", a, c)
+		}
+	}
+}
+
+func TestSectionClone(t *testing.T) {
+	file, err := Parse(strings.NewReader("[*.go]\nindent_style = tab\nindent_size = 8\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	orig := file.Sections[0]
+	clone := orig.Clone()
+	clone.Set(Property{Name: "indent_size", Value: "4"})
+	clone.Add(Property{Name: "charset", Value: "utf-8"})
+	if got := orig.String(); got != "[*.go]\nindent_size=8\nindent_style=tab\n" {
+		t.Fatalf("modifying a clone changed the original:\n%s", got)
+	}
+	if clone.rx != nil {
+		t.Fatal("clone kept the compiled pattern")
+	}
+	if ok, err := clone.MatchError("main.go"); !ok || err != nil {
+		t.Fatalf("clone did not match: %t, %v", ok, err)
+	}
+}
+
+func TestFileClone(t *testing.T) {
+	file, err := Parse(strings.NewReader("root = true\n[*.go]\nindent_style = tab\n[*.md]\nindent_style = space\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	want := file.String()
+	clone := file.Clone()
+	clone.Root = false
+	clone.Sections[0].Set(Property{Name: "indent_style", Value: "space"})
+	clone.Sections = append(clone.Sections[:1], Section{Name: "*"})
+	if got := file.String(); got != want {
+		t.Fatalf("modifying a clone changed the original:\n%s", got)
+	}
+	for _, section := range clone.Sections {
+		if section.rx != nil {
+			t.Fatal("clone kept a compiled pattern")
+		}
+	}
+}
+
+func TestMatchingSections(t *testing.T) {
+	file, err := Parse(strings.NewReader("[*]\na = 1\n[*.md]\nb = 2\n[[go]]\nc = 3\n[*_test.go]\nd = 4\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, section := range file.MatchingSections("pkg/main_test.go", []string{"go"}) {
+		got = append(got, section.Name)
+	}
+	if want := []string{"*", "[go]", "*_test.go"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+}
+
+func TestRelName(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		configDir, filePath string
+		want                string // empty if an error is expected
+	}{
+		{dir, filepath.Join(dir, "main.go"), "main.go"},
+		{dir, filepath.Join(dir, "sub", "dir", "main.go"), "sub/dir/main.go"},
+		{dir + string(filepath.Separator), filepath.Join(dir, "sub", "..", "main.go"), "main.go"},
+		{"repo", filepath.Join("repo", "sub", "main.go"), "sub/main.go"},
+		{".", "main.go", "main.go"},
+		{".", "..main.go", "..main.go"},
+		{dir, dir, ""},
+		{filepath.Join(dir, "sub"), filepath.Join(dir, "main.go"), ""},
+		{filepath.Join(dir, "sub"), filepath.Join(dir, "subdir", "main.go"), ""},
+		{dir, "main.go", ""},
+	}
+	for _, tc := range tests {
+		got, err := RelName(tc.configDir, tc.filePath)
+		switch {
+		case tc.want == "" && err == nil:
+			t.Errorf("RelName(%q, %q) did not error", tc.configDir, tc.filePath)
+		case tc.want != "" && err != nil:
+			t.Errorf("RelName(%q, %q): unexpected error: %v", tc.configDir, tc.filePath, err)
+		case got != tc.want:
+			t.Errorf("RelName(%q, %q): want %q, got %q", tc.configDir, tc.filePath, tc.want, got)
+		}
+	}
+}
+
+func TestPropertyNames(t *testing.T) {
+	file, err := Parse(strings.NewReader("root = true\n[*]\nindent_style = tab\ncharset = utf-8\n[*.md]\nindent_style = space\nmax_line_length = off\n[*.txt]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"charset", "indent_style", "max_line_length"}
+	if got := file.PropertyNames(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+	if got := (&File{Root: true}).PropertyNames(); got != nil {
+		t.Fatalf("want no names for an empty file, got %q", got)
+	}
+}
+
+func TestPrintFinalNewline(t *testing.T) {
+	tests := []struct {
+		file File
+		want string
+	}{
+		{File{}, ""},
+		{File{Root: true}, "root=true\n"},
+		{File{Comment: "foo"}, "# foo\n"},
+		{File{Sections: []Section{{Name: "*"}}}, "[*]\n"},
+		{
+			File{Root: true, Sections: []Section{{Name: "*", Properties: []Property{{Name: "a", Value: "b"}}}}},
+			"root=true\n\n[*]\na=b\n",
+		},
+	}
+	for _, tc := range tests {
+		if got := tc.file.String(); got != tc.want {
+			t.Errorf("want %q, got %q", tc.want, got)
+		}
+		var b strings.Builder
+		n, err := PrintOptions{NoFinalNewline: true}.Print(&b, &tc.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := strings.TrimSuffix(tc.want, "\n")
+		if got := b.String(); got != want || n != int64(len(want)) {
+			t.Errorf("NoFinalNewline: want %q, got %q with n=%d", want, got, n)
+		}
+	}
+}
@@ -5,6 +5,7 @@ package editorconfig_test
 
 import (
 	"fmt"
+	"os"
 	"strings"
 
 	"mvdan.cc/editorconfig"
@@ -23,9 +24,9 @@ func ExampleFind() {
 	fmt.Println(props.InsertFinalNewline())
 
 	// Output:
-	// indent_style=tab
-	// indent_size=8
 	// end_of_line=lf
+	// indent_size=8
+	// indent_style=tab
 	// insert_final_newline=true
 	//
 	// tab
@@ -38,11 +39,13 @@ func ExampleParse() {
 	config := `
 root = true
 
-[*] # match all files
+# match all files
+[*]
 end_of_line = lf
 insert_final_newline = true
 
-[*.go] # only match Go
+; only match Go
+[*.go]
 indent_style = tab
 indent_size = 8
 `
@@ -60,8 +63,8 @@ indent_size = 8
 	// insert_final_newline=true
 	//
 	// [*.go]
-	// indent_style=tab
 	// indent_size=8
+	// indent_style=tab
 }
 
 func ExampleFile_Filter_language() {
@@ -89,12 +92,193 @@ indent_size = 4
 
 	// Output:
 	// * main.go:
-	// indent_style=tab
-	// indent_size=8
 	// end_of_line=lf
+	// indent_size=8
+	// indent_style=tab
 	//
 	// * main_test.go:
+	// end_of_line=lf
+	// indent_size=4
+	// indent_style=tab
+}
+
+func ExampleParseOptions_comments() {
+	config := `
+# Top-most EditorConfig file.
+root = true
+
+; Unix-style newlines.
+[*]
+end_of_line = lf
+
+# Go uses tabs.
+#
+# See gofmt.
+[*.go]
+indent_style = tab
+# Tabs are eight columns wide.
+indent_size = 8
+`
+	file, err := editorconfig.ParseOptions{Comments: true}.Parse(strings.NewReader(config))
+	if err != nil {
+		panic(err)
+	}
+	file.Sections[1].Set(editorconfig.Property{Name: "indent_size", Value: "4"})
+	fmt.Println(file)
+
+	// Output:
+	// # Top-most EditorConfig file.
+	// root=true
+	//
+	// ; Unix-style newlines.
+	// [*]
+	// end_of_line=lf
+	//
+	// # Go uses tabs.
+	// #
+	// # See gofmt.
+	// [*.go]
+	// # Tabs are eight columns wide.
 	// indent_size=4
 	// indent_style=tab
+}
+
+func ExampleFile_Merge() {
+	base, err := editorconfig.Parse(strings.NewReader(`
+[*]
+end_of_line = lf
+indent_style = space
+`))
+	if err != nil {
+		panic(err)
+	}
+	overlay, err := editorconfig.Parse(strings.NewReader(`
+root = true
+
+[*.go]
+indent_style = tab
+`))
+	if err != nil {
+		panic(err)
+	}
+	merged := base.Merge(overlay)
+	fmt.Println(merged)
+	fmt.Println(merged.Filter("main.go", nil, nil))
+
+	// Output:
+	// root=true
+	//
+	// [*]
+	// end_of_line=lf
+	// indent_style=space
+	//
+	// [*.go]
+	// indent_style=tab
+	//
 	// end_of_line=lf
+	// indent_style=tab
+}
+
+func ExampleFile_Normalize() {
+	file, err := editorconfig.Parse(strings.NewReader(`
+[*.go]
+indent_style = tab
+indent_size = 4
+
+[*.md]
+trim_trailing_whitespace = false
+
+[*.go]
+indent_size = 8
+`))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(file.Normalize())
+
+	// Output:
+	// [*.go]
+	// indent_size=8
+	// indent_style=tab
+	//
+	// [*.md]
+	// trim_trailing_whitespace=false
+}
+
+func ExampleFindReader() {
+	config := `
+[*]
+indent_style = space
+indent_size = 4
+
+[*.go]
+indent_style = tab
+`
+	props, err := editorconfig.FindReader(strings.NewReader(config), "cmd/main.go", nil)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(props)
+
+	// Output:
+	// indent_size=4
+	// indent_style=tab
+}
+
+func ExamplePrintOptions() {
+	file := &editorconfig.File{
+		Comment: "Generated by mytool; do not edit.",
+		Root:    true,
+		Sections: []editorconfig.Section{{
+			Name:    "*.go",
+			Comment: "Go code is formatted by gofmt.",
+			Properties: []editorconfig.Property{
+				{Name: "indent_style", Value: "tab"},
+			},
+		}},
+	}
+	editorconfig.PrintOptions{CommentPrefix: ";"}.Print(os.Stdout, file)
+
+	// Output:
+	// ; Generated by mytool; do not edit.
+	// root=true
+	//
+	// ; Go code is formatted by gofmt.
+	// [*.go]
+	// indent_style=tab
+}
+
+func ExampleGenerate() {
+	file := editorconfig.Generate(map[string][]byte{
+		"main.go":     []byte("package main\n\nfunc main() {\n\tprintln()\n}\n"),
+		"util.go":     []byte("package main\n\nfunc f() {\n\tif true {\n\t\tf()\n\t}\n}\n"),
+		"setup.py":    []byte("def f():\n    if x:\n        y()\n"),
+		"run.bat":     []byte("@echo off\r\nif 1 (\r\n  echo\r\n)\r\n"),
+		"LICENSE":     []byte("Copyright\n"),
+		"config.yaml": []byte("a:\n  b: c\n"),
+	})
+	fmt.Println(file)
+
+	// Output:
+	// root=true
+	//
+	// [*]
+	// end_of_line=lf
+	// insert_final_newline=true
+	//
+	// [*.bat]
+	// end_of_line=crlf
+	// indent_size=2
+	// indent_style=space
+	//
+	// [*.go]
+	// indent_style=tab
+	//
+	// [*.py]
+	// indent_size=4
+	// indent_style=space
+	//
+	// [*.yaml]
+	// indent_size=2
+	// indent_style=space
 }
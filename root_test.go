@@ -0,0 +1,47 @@
+// Copyright (c) 2019, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+//go:build go1.24
+
+package editorconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQueryRoot(t *testing.T) {
+	outside := t.TempDir()
+	writeFile(t, filepath.Join(outside, ".editorconfig"), "[*]\nindent_style = space\n")
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, ".editorconfig"), "[*]\ncharset = utf-8\n")
+	writeFile(t, filepath.Join(dir, "sub", "main.go"), "")
+	if err := os.Symlink(outside, filepath.Join(dir, "escape")); err != nil {
+		t.Skip(err)
+	}
+
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	query := Query{FS: root.FS()}
+
+	section, err := query.Find("sub/main.go", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := section.String(), "charset=utf-8\n"; got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+
+	// The symbolic link points outside the root, so its EditorConfig
+	// file must not be used.
+	if _, err := query.Find("escape/main.go", nil); err == nil {
+		t.Fatalf("Find via a symbolic link escaping the root did not error")
+	}
+	if _, err := query.Find("../main.go", nil); err == nil {
+		t.Fatalf("Find with a name outside the root did not error")
+	}
+}
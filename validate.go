@@ -0,0 +1,269 @@
+// Copyright (c) 2019, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package editorconfig
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// Issue is a problem found by File.Validate.
+type Issue struct {
+	// Section is the index of the section with the problem within the
+	// file's Sections, or -1 if the problem is at the top of the file.
+	Section int
+	// Property is the name of the property with the problem.
+	Property string
+	// Msg describes the problem.
+	Msg string
+}
+
+func (i Issue) Error() string { return fmt.Sprintf("%s: %s", i.Property, i.Msg) }
+
+// Validate checks a file for likely mistakes, returning an issue for each of
+// them. The issues before the first section come first, in the order they
+// appear in the file, followed by those in each section, sorted by property
+// name like the section's properties. The checks are:
+//
+//   - root being declared more than once, or inside a section
+//   - properties other than root before the first section, which are ignored
+//   - unknown properties whose names are close to well-known ones, such as
+//     "indent_styl"
+//   - well-known properties whose values don't match the domains defined by
+//     the spec, such as indent_size not being a positive integer or "tab"
+//
+// Properties with the special "unset" value are valid. The checks which depend
+// on the lines before the first section only work on files returned by Parse,
+// or by Clone, Merge, or Normalize on such files.
+//
+// Note that Parse keeps invalid values, as editors are meant to ignore them.
+// This method is useful to warn about them instead.
+func (f *File) Validate() []Issue {
+	var issues []Issue
+	roots := 0
+	for _, prop := range f.preamble {
+		if prop.Name != "root" {
+			issues = append(issues, Issue{Section: -1, Property: prop.Name,
+				Msg: "appears before the first section, so it is ignored"})
+			continue
+		}
+		if roots++; roots == 2 {
+			issues = append(issues, Issue{Section: -1, Property: prop.Name,
+				Msg: "is declared more than once"})
+		}
+		if err := validateValue(prop.Name, prop.Value); err != nil {
+			issues = append(issues, Issue{Section: -1, Property: prop.Name, Msg: err.Error()})
+		}
+	}
+	for i, section := range f.Sections {
+		for _, prop := range section.Properties {
+			if prop.Name == "root" {
+				issues = append(issues, Issue{Section: i, Property: prop.Name,
+					Msg: "is only valid at the top of the file, before any section"})
+				continue
+			}
+			if known := closestKnownProperty(prop.Name); known != "" {
+				issues = append(issues, Issue{Section: i, Property: prop.Name,
+					Msg: fmt.Sprintf("is unknown; did you mean %q?", known)})
+				continue
+			}
+			if err := validateValue(prop.Name, prop.Value); err != nil {
+				issues = append(issues, Issue{Section: i, Property: prop.Name, Msg: err.Error()})
+			}
+		}
+	}
+	return issues
+}
+
+// Conflict is a property set to different values by more than one of the
+// sections which apply to a file, as found by File.Conflicts.
+type Conflict struct {
+	// Property is the name of the property.
+	Property string
+	// Sections holds the indexes of the competing sections within the
+	// file's Sections, in increasing order. The last one takes precedence.
+	Sections []int
+}
+
+// Conflicts reports the properties which are set to different values by
+// more than one of the sections applying to a file, given its name and
+// optional languages. The conflicts are sorted by property name.
+//
+// Sections named "*" or "**", which match all files, usually hold defaults
+// meant to be overridden, so they are not considered. Other overlapping
+// sections, such as "[*.go]" and "[*.{go,js}]", are likely a mistake when they
+// disagree, even if only the last one takes effect.
+func (f *File) Conflicts(name string, languages []string) []Conflict {
+	name = filepath.ToSlash(name)
+	m := matcher{cache: mapCache{}}
+	first := make(map[string]string)   // the first value for each property
+	sections := make(map[string][]int) // the sections setting each property
+	conflicting := make(map[string]bool)
+	for i, section := range f.Sections {
+		if section.Language == "" && (section.Name == "*" || section.Name == "**") {
+			continue
+		}
+		if ok, _ := section.match(name, languages, m); !ok {
+			continue
+		}
+		for _, prop := range section.Properties {
+			if value, ok := first[prop.Name]; !ok {
+				first[prop.Name] = prop.Value
+			} else if value != prop.Value {
+				conflicting[prop.Name] = true
+			}
+			sections[prop.Name] = append(sections[prop.Name], i)
+		}
+	}
+	var conflicts []Conflict
+	for prop := range conflicting {
+		conflicts = append(conflicts, Conflict{Property: prop, Sections: sections[prop]})
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].Property < conflicts[j].Property
+	})
+	return conflicts
+}
+
+// KnownProperties are the property names considered well-known by
+// File.Validate, File.UnknownProperties, and File.LikelyTypos. They are the
+// properties defined by the spec, along with the widely supported
+// max_line_length.
+//
+// Programs may append to it to recognize their own properties, but it must not
+// be modified while it is being used.
+var KnownProperties = []string{
+	"root",
+	"indent_style",
+	"indent_size",
+	"tab_width",
+	"end_of_line",
+	"charset",
+	"trim_trailing_whitespace",
+	"insert_final_newline",
+	"max_line_length",
+}
+
+// UnknownProperties returns the properties in the file's sections whose names
+// are not in KnownProperties, section by section, sorted by name within each
+// section like the section's properties. Properties which are likely
+// misspellings of a well-known property are not included; see LikelyTypos.
+//
+// Unknown properties are allowed by the spec, but are ignored by most tools.
+func (f *File) UnknownProperties() []Property {
+	var props []Property
+	for _, section := range f.Sections {
+		for _, prop := range section.Properties {
+			if !slices.Contains(KnownProperties, prop.Name) && closestKnownProperty(prop.Name) == "" {
+				props = append(props, prop)
+			}
+		}
+	}
+	return props
+}
+
+// LikelyTypos returns the properties in the file's sections whose names are
+// not in KnownProperties, but are within a few edits of one of them, such as
+// "indent_stye". Names shorter than six characters may only be one edit away,
+// and longer names one edit per four characters. They are returned in the
+// same order as UnknownProperties.
+func (f *File) LikelyTypos() []Property {
+	var props []Property
+	for _, section := range f.Sections {
+		for _, prop := range section.Properties {
+			if closestKnownProperty(prop.Name) != "" {
+				props = append(props, prop)
+			}
+		}
+	}
+	return props
+}
+
+// closestKnownProperty returns the well-known property which name is likely a
+// misspelling of, or an empty string if there is none, such as when the name
+// is a well-known property itself.
+func closestKnownProperty(name string) string {
+	// Short names are too likely to be custom properties which happen to be
+	// close to a well-known one, like "foo" and "root".
+	maxEdits := 1
+	if len(name) >= 6 {
+		maxEdits = len(name) / 4
+	}
+	best, bestDist := "", maxEdits+1
+	for _, known := range KnownProperties {
+		if known == name {
+			return ""
+		}
+		if dist := editDistance(name, known); dist < bestDist {
+			best, bestDist = known, dist
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// validateValue checks the value of a well-known property. Unknown properties
+// are always valid.
+func validateValue(name, value string) error {
+	if value == "unset" {
+		return nil
+	}
+	valid := true
+	var domain string
+	switch name {
+	case "indent_style":
+		domain = `"tab" or "space"`
+		valid = value == "tab" || value == "space"
+	case "indent_size":
+		domain = `a positive integer or "tab"`
+		valid = value == "tab" || isPositiveInt(value)
+	case "tab_width":
+		domain = "a positive integer"
+		valid = isPositiveInt(value)
+	case "end_of_line":
+		domain = `"lf", "cr", or "crlf"`
+		valid = value == "lf" || value == "cr" || value == "crlf"
+	case "charset":
+		domain = `"latin1", "utf-8", "utf-8-bom", "utf-16be", or "utf-16le"`
+		valid = validCharset(value)
+	case "trim_trailing_whitespace", "insert_final_newline", "root":
+		domain = `"true" or "false"`
+		valid = value == "true" || value == "false"
+	case "max_line_length":
+		domain = `a positive integer or "off"`
+		valid = value == "off" || isPositiveInt(value)
+	}
+	if !valid {
+		return fmt.Errorf("invalid value %q, must be %s", value, domain)
+	}
+	return nil
+}
+
+func isPositiveInt(s string) bool {
+	n, err := strconv.Atoi(s)
+	return err == nil && n > 0
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2019, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package editorconfig
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	file, err := Parse(strings.NewReader(`
+root = true
+indent_style = tab
+root = yes
+
+[*]
+indent_style = tabs
+indent_size = banana
+tab_width = 0
+end_of_line = lf
+charset = utf8
+insert_final_newline = maybe
+trim_trailing_whitespace = unset
+max_line_length = off
+custom = anything
+
+[*.go]
+indent_size = tab
+indent_styl = tab
+root = true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, issue := range file.Validate() {
+		got = append(got, fmt.Sprintf("%d %v", issue.Section, issue))
+	}
+	want := []string{
+		`-1 indent_style: appears before the first section, so it is ignored`,
+		`-1 root: is declared more than once`,
+		`-1 root: invalid value "yes", must be "true" or "false"`,
+		`0 charset: invalid value "utf8", must be "latin1", "utf-8", "utf-8-bom", "utf-16be", or "utf-16le"`,
+		`0 indent_size: invalid value "banana", must be a positive integer or "tab"`,
+		`0 indent_style: invalid value "tabs", must be "tab" or "space"`,
+		`0 insert_final_newline: invalid value "maybe", must be "true" or "false"`,
+		`0 tab_width: invalid value "0", must be a positive integer`,
+		`1 indent_styl: is unknown; did you mean "indent_style"?`,
+		`1 root: is only valid at the top of the file, before any section`,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("want:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	// The checks before the first section still work on derived files.
+	for name, derived := range map[string]*File{
+		"Clone":     file.Clone(),
+		"Normalize": file.Normalize(),
+		"Merge":     (&File{}).Merge(file),
+	} {
+		issues := derived.Validate()
+		if len(issues) != len(want) || issues[0].Section != -1 {
+			t.Errorf("%s: want %d issues starting before the first section, got %v", name, len(want), issues)
+		}
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	file, err := Parse(strings.NewReader(`
+[*]
+indent_style = space
+indent_size = 2
+
+[*.go]
+indent_style = tab
+indent_size = 8
+charset = utf-8
+
+[*.{go,js}]
+indent_size = 4
+charset = utf-8
+
+[[go]]
+indent_size = 2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		langs []string
+		want  string
+	}{
+		{"main.go", nil, "[{indent_size [1 2]}]"},
+		{"main.go", []string{"go"}, "[{indent_size [1 2 3]}]"},
+		{"main.js", nil, "[]"},
+		{"main.c", nil, "[]"},
+	}
+	for _, tc := range tests {
+		if got := fmt.Sprint(file.Conflicts(tc.name, tc.langs)); got != tc.want {
+			t.Errorf("Conflicts(%q, %q): want %s, got %s", tc.name, tc.langs, tc.want, got)
+		}
+	}
+}
+
+func TestUnknownProperties(t *testing.T) {
+	file, err := Parse(strings.NewReader(`
+[*]
+indent_stye = tab
+custom = anything
+indent_size = 4
+
+[*.go]
+my_linter_option = true
+tab_widht = 8
+foo = 1
+key = 2
+rot = true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(file.UnknownProperties()), "[custom=anything foo=1 key=2 my_linter_option=true]"; got != want {
+		t.Errorf("UnknownProperties: want %s, got %s", want, got)
+	}
+	if got, want := fmt.Sprint(file.LikelyTypos()), "[indent_stye=tab rot=true tab_widht=8]"; got != want {
+		t.Errorf("LikelyTypos: want %s, got %s", want, got)
+	}
+
+	defer func(known []string) { KnownProperties = known }(KnownProperties)
+	KnownProperties = append(slices.Clip(KnownProperties), "my_linter_option")
+	if got, want := fmt.Sprint(file.UnknownProperties()), "[custom=anything foo=1 key=2]"; got != want {
+		t.Errorf("UnknownProperties with an extended set: want %s, got %s", want, got)
+	}
+}